@@ -0,0 +1,656 @@
+// Subcommands.
+//
+// Every subcommand has its own flag set and accepts multiple files. Running
+// videofix without a subcommand is the same as running "videofix fix".
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/marcopaganini/videofix/fix"
+)
+
+// command describes a videofix subcommand.
+type command struct {
+	name string
+	args string // Arguments shown in the usage message.
+	help string
+	run  func(ctx context.Context, args []string) error
+}
+
+// commands lists all subcommands, in the order shown by usage.
+var commands []command
+
+func init() {
+	commands = []command{
+		{name: "fix", args: "[options] <file|dir>...", help: "Fix the files (the default command)", run: fixCommand},
+		{name: "inspect", args: "[--sidecars] [--json] [options] <file|dir>...", help: "Show the tracks in the files", run: inspectCommand},
+		{name: "plan", args: "[--out plan.json] [options] <file|dir>...", help: "Save the plans to fix the files", run: planCommand},
+		{name: "apply", args: "<plan.json>...", help: "Execute saved plans", run: applyCommand},
+		{name: "scan", args: "[options] <file|dir>...", help: "List the files that need fixing", run: scanCommand},
+		{name: "doctor", args: "[options]", help: "Check the external programs", run: doctorCommand},
+		{name: "watch", args: "[options] <dir>...", help: "Fix new files in the directories as they arrive", run: watchCommand},
+		{name: "serve", args: "[--listen addr] [--jobs file] [options]", help: "Run the HTTP API", run: serveCommand},
+		{name: "history", args: "[--state file] [--json] <file>...", help: "Show the processing history of the files", run: historyCommand},
+		{name: "cleanup", args: "[--dry-run] <dir>...", help: "Remove stale temporary files left by crashed runs", run: cleanupCommand},
+	}
+}
+
+// findCommand returns the subcommand with the given name, or nil.
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// newFlagSet returns a flag set for the named subcommand.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		cmd := findCommand(name)
+		progname := filepath.Base(os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s %s %s\n\n", progname, cmd.name, cmd.args)
+		fmt.Fprintf(os.Stderr, "%s.\n\n", cmd.help)
+		fmt.Fprintln(os.Stderr, "Options:")
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// optionFlags returns a flag set for the named subcommand with flags for
+// all fix options, and the value of the --config flag.
+func optionFlags(name string) (*flag.FlagSet, *string) {
+	fs := newFlagSet(name)
+	defaults := fix.DefaultOptions()
+	defaults.BindFlags(fs)
+	config := fs.String("config", userConfigFile(), "Configuration file")
+	return fs, config
+}
+
+// inputFiles returns the video files to process. Directories are replaced
+// by the largest video file in them.
+func inputFiles(paths []string) ([]string, error) {
+	var ret []string
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("file not found: %s", path)
+		}
+		if !fi.IsDir() {
+			ret = append(ret, path)
+			continue
+		}
+		file, err := findVideoFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("trying to find movie in directory %s: %w", path, err)
+		}
+		log.Printf("Using file: %s", file)
+		ret = append(ret, file)
+	}
+	return ret, nil
+}
+
+// isVideoFile returns true if the file has a .mkv or .mp4 extension.
+func isVideoFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".mkv" || ext == ".mp4"
+}
+
+// findVideoFile scans the passed directory and returns the largest file with a
+// .mkv or .mp4 extension. If no files with these extensions exist, an error
+// is returned.
+func findVideoFile(dir string) (string, error) {
+	var largestFile string
+	var largestSize int64
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if isVideoFile(info.Name()) {
+			if info.Size() > largestSize {
+				largestFile = path
+				largestSize = info.Size()
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if largestFile == "" {
+		return "", fmt.Errorf("no MKV or MP4 files found")
+	}
+	return largestFile, nil
+}
+
+// scanFiles returns all video files in paths. Directories are searched
+// recursively.
+func scanFiles(paths []string) ([]string, error) {
+	var ret []string
+	for _, path := range paths {
+		err := filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && isVideoFile(path) {
+				ret = append(ret, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// fixCommand implements "videofix fix": fix all files.
+func fixCommand(ctx context.Context, args []string) error {
+	fs, config := optionFlags("fix")
+	dir := fs.String("dir", "", "Deprecated: pass the directory as an argument instead")
+	input := fs.String("input", "", "Deprecated: pass the file as an argument instead")
+	show := fs.Bool("show-config", false, "Show the effective settings for each file and exit")
+	state := fs.String("state", defaultStateFile(), "State file recording the fixed files (blank to disable)")
+	force := fs.Bool("force", false, "Process files even if unchanged since they were fixed")
+	fs.Parse(args)
+
+	paths := fs.Args()
+	for _, path := range []string{*input, *dir} {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	if *show {
+		if len(paths) == 0 {
+			paths = []string{"."}
+		}
+		for _, path := range paths {
+			ffs, settings, err := fileFlags(fs, *config, path, new(fix.Options))
+			if err != nil {
+				return err
+			}
+			fmt.Printf("# %s\n", path)
+			showConfig(ffs, settings, cmdlineFlags(fs))
+		}
+		return nil
+	}
+	if len(paths) == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	files, err := inputFiles(paths)
+	if err != nil {
+		return err
+	}
+	db, err := loadState(*state)
+	if err != nil {
+		return err
+	}
+
+	f := &fixer{fs: fs, config: *config, db: db, force: *force, tools: toolChecker{}}
+	failed := 0
+	for i, file := range files {
+		if err := interrupted(ctx, i, len(files)); err != nil {
+			return err
+		}
+		if err := f.fix(ctx, file, batch{i, len(files)}); err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d file(s) failed", failed, len(files))
+	}
+	return nil
+}
+
+// fixer fixes files with the options from the command line (fs) and the
+// configuration files, recording the outcomes in the state file.
+type fixer struct {
+	fs     *flag.FlagSet
+	config string
+	db     *stateDB
+	force  bool
+	tools  toolChecker
+	// wrote, if set, is called with the files written by successful runs.
+	wrote func(file string)
+}
+
+// fix fixes a file in a batch, showing the progress (see run).
+func (f *fixer) fix(ctx context.Context, file string, b batch) error {
+	opts, err := fileOptions(f.fs, f.config, file)
+	if err != nil {
+		log.Printf("%s: ERROR: %v", file, err)
+		return err
+	}
+	return f.run(ctx, file, opts, progressPrinter(b))
+}
+
+// run fixes a file with the given options and logs the outcome, calling
+// fn with the progress. Files fixed with the same settings and unchanged
+// since are skipped, unless forced. It returns the error if the file failed.
+func (f *fixer) run(ctx context.Context, file string, opts fix.Options, fn fix.ProgressFunc) error {
+	if !f.force {
+		if last, ok := f.db.unchanged(file, opts); ok {
+			log.Printf("%s: Unchanged since fixed on %s, skipping (use --force to process).", file, last.Time.Local().Format(time.DateTime))
+			return nil
+		}
+	}
+	err := f.tools.check(ctx, &opts)
+	var plan fix.Plan
+	if err == nil {
+		if opts.Lang == "" {
+			log.Printf("No language specified. All tracks will be copied.")
+		}
+		plan, err = makePlan(ctx, file, opts)
+	}
+	if err == nil {
+		printPlan(plan)
+		err = preHook(ctx, plan)
+		if errors.Is(err, errSkipped) {
+			log.Printf("%s: File %v.", file, err)
+			recordOutcome(f.db, file, "", opts, outcomeSkipped, err.Error())
+			return nil
+		}
+	}
+	if err == nil {
+		err = runPlan(ctx, plan, fn)
+	}
+	if err != nil {
+		log.Printf("%s: ERROR: %v", file, err)
+		if !opts.DryRun {
+			recordOutcome(f.db, file, "", opts, outcomeFailed, err.Error())
+			postHook(ctx, file, opts, plan, err)
+		}
+		return err
+	}
+	log.Printf("%s: Operation successful.", file)
+	if !opts.DryRun && f.wrote != nil {
+		f.wrote(plan.Output)
+		f.wrote(fixedFile(plan))
+	}
+	if !opts.DryRun {
+		reason := "no changes"
+		if changes := plan.Changes(); len(changes) > 0 {
+			reason = strings.Join(changes, "; ")
+		}
+		recordOutcome(f.db, file, fixedFile(plan), opts, outcomeFixed, reason)
+		postHook(ctx, file, opts, plan, nil)
+	}
+	return nil
+}
+
+// inspectResult holds the tracks of a file, as printed by "inspect --json".
+type inspectResult struct {
+	File   string      `json:"file"`
+	Tracks []fix.Track `json:"tracks"`
+}
+
+// inspectCommand implements "videofix inspect": show the tracks in the
+// files.
+func inspectCommand(ctx context.Context, args []string) error {
+	// Only --sidecars and the tool paths are used from the options.
+	fs, config := optionFlags("inspect")
+	asJSON := fs.Bool("json", false, "Output in JSON format")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	files, err := inputFiles(fs.Args())
+	if err != nil {
+		return err
+	}
+
+	tools := toolChecker{}
+	results := []inspectResult{}
+	for i, file := range files {
+		if err := interrupted(ctx, i, len(files)); err != nil {
+			return err
+		}
+		opts, err := fileOptions(fs, *config, file)
+		if err == nil {
+			err = tools.probe(ctx, &opts)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		tracks, err := fix.Probe(ctx, file, opts)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		if opts.Sidecars {
+			sc, err := fix.ProbeSidecars(ctx, file, opts)
+			if err != nil {
+				return fmt.Errorf("%s: %w", file, err)
+			}
+			tracks = append(tracks, sc...)
+		}
+		results = append(results, inspectResult{File: file, Tracks: tracks})
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(results)
+	}
+	for _, result := range results {
+		printHeader("File: " + result.File)
+		printTracks(result.Tracks)
+	}
+	return nil
+}
+
+// printTracks prints a table with the tracks.
+func printTracks(tracks []fix.Track) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTYPE\tCODEC\tLANG\tFLAGS\tDETAILS\tNAME")
+	for _, track := range tracks {
+		var flags []string
+		for _, f := range []struct {
+			set  bool
+			name string
+		}{
+			{track.Properties.DefaultTrack, "default"},
+			{track.Properties.ForcedTrack, "forced"},
+			{track.Properties.FlagCommentary, "commentary"},
+			{track.Properties.FlagHearingImpaired, "sdh"},
+		} {
+			if f.set {
+				flags = append(flags, f.name)
+			}
+		}
+
+		var details []string
+		if track.Properties.PixelDimensions != "" {
+			details = append(details, track.Properties.PixelDimensions)
+		}
+		if hdr := track.HDR.String(); hdr != "" {
+			details = append(details, hdr)
+		}
+		if track.Properties.AudioChannels > 0 {
+			details = append(details, fmt.Sprintf("%dch", track.Properties.AudioChannels))
+		}
+		if track.Sidecar {
+			details = append(details, filepath.Base(track.File))
+		}
+
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", track.ID, track.Type, track.CodecID,
+			dash(track.Properties.Language), dash(strings.Join(flags, ",")),
+			dash(strings.Join(details, " ")), track.Properties.TrackName)
+	}
+	w.Flush()
+}
+
+// dash returns s, or "-" if s is blank.
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// scanCommand implements "videofix scan": list the files that need fixing
+// and why, using the same options as "fix".
+func scanCommand(ctx context.Context, args []string) error {
+	fs, config := optionFlags("scan")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	files, err := scanFiles(fs.Args())
+	if err != nil {
+		return err
+	}
+
+	tools := toolChecker{}
+	failed, pending := 0, 0
+	for i, file := range files {
+		if err := interrupted(ctx, i, len(files)); err != nil {
+			return err
+		}
+		opts, err := fileOptions(fs, *config, file)
+		if err == nil {
+			err = tools.probe(ctx, &opts)
+		}
+		if err == nil {
+			var plan fix.Plan
+			if plan, err = makePlan(ctx, file, opts); err == nil {
+				if changes := plan.Changes(); len(changes) > 0 {
+					pending++
+					fmt.Println(file)
+					for _, change := range changes {
+						fmt.Println("  " + change)
+					}
+				}
+			}
+		}
+		if err != nil {
+			log.Printf("%s: ERROR: %v", file, err)
+			failed++
+		}
+	}
+	log.Printf("%d of %d file(s) need fixing.", pending, len(files))
+	if failed > 0 {
+		return fmt.Errorf("unable to scan %d of %d file(s)", failed, len(files))
+	}
+	return nil
+}
+
+// interrupted returns an error if the context was cancelled (by a signal)
+// after processing done of total files.
+func interrupted(ctx context.Context, done int, total int) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("interrupted after %d of %d file(s)", done, total)
+	}
+	return nil
+}
+
+// toolChecker probes the external programs once for each set of tool
+// paths, as the paths may be set in per-directory configuration files.
+type toolChecker map[fix.ToolPaths]fix.Capabilities
+
+// probe returns an error if any of the external programs used with opts
+// can't be used, and sets the ffmpeg version in opts.
+func (tc toolChecker) probe(ctx context.Context, opts *fix.Options) error {
+	caps, ok := tc[opts.ToolPaths]
+	if !ok {
+		caps = fix.ProbeTools(ctx, *opts)
+		tc[opts.ToolPaths] = caps
+	}
+	if err := caps.Check(); err != nil {
+		return fmt.Errorf("%w (see \"videofix doctor\")", err)
+	}
+	opts.FFmpegVersion = caps.Version("ffmpeg")
+	return nil
+}
+
+// check is like probe, but also checks that the features needed by opts
+// are available.
+func (tc toolChecker) check(ctx context.Context, opts *fix.Options) error {
+	if err := tc.probe(ctx, opts); err != nil {
+		return err
+	}
+	return tc[opts.ToolPaths].CheckOptions(*opts)
+}
+
+// doctorCommand implements "videofix doctor": report the versions of the
+// external programs and the features they enable.
+func doctorCommand(ctx context.Context, args []string) error {
+	fs, config := optionFlags("doctor")
+	fs.Parse(args)
+
+	// Tool paths may come from the configuration of the current directory.
+	opts, err := fileOptions(fs, *config, ".")
+	if err != nil {
+		return err
+	}
+	caps := fix.ProbeTools(ctx, opts)
+
+	printHeader("External programs")
+	for _, tool := range caps.Tools {
+		version := tool.Version
+		if version == "" {
+			version = "unknown version"
+		}
+		switch {
+		case tool.Path == "":
+			log.Printf("  %s: NOT FOUND: %v", tool.Name, tool.Err)
+		case tool.Err != nil:
+			log.Printf("  %s: %s (%s): ERROR: %v", tool.Name, version, tool.Path, tool.Err)
+		default:
+			log.Printf("  %s: %s (%s), minimum %s: OK", tool.Name, version, tool.Path, tool.MinVersion)
+		}
+	}
+
+	printHeader("ffmpeg encoders")
+	var names []string
+	for name := range caps.Encoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		status := "not available"
+		if caps.Encoders[name] {
+			status = "available"
+		}
+		log.Printf("  %s: %s", name, status)
+	}
+	if len(names) == 0 {
+		log.Println("  Unable to list the ffmpeg encoders.")
+	}
+
+	printHeader("Features")
+	for _, feature := range caps.Features() {
+		status := "disabled"
+		if feature.Enabled {
+			status = "enabled"
+		}
+		log.Printf("  %s (%s): %s", feature.Name, feature.Encoder, status)
+	}
+
+	return caps.Check()
+}
+
+// cleanupCommand implements "videofix cleanup": remove the stale temporary
+// files under the given directories. Files in use are reported and kept.
+func cleanupCommand(ctx context.Context, args []string) error {
+	fs := newFlagSet("cleanup")
+	dryRun := fs.Bool("dry-run", false, "Only list the temporary files")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	var temps []fix.TempInfo
+	for _, dir := range fs.Args() {
+		found, err := fix.FindTemps(dir)
+		if err != nil {
+			return err
+		}
+		temps = append(temps, found...)
+	}
+
+	failed, removed := 0, 0
+	for _, temp := range temps {
+		switch {
+		case !temp.Stale:
+			log.Printf("%s: keeping, %s", temp.Path, temp.Reason)
+		case *dryRun:
+			log.Printf("%s: stale, %s (dry run, not removed)", temp.Path, temp.Reason)
+		default:
+			if err := temp.Remove(); err != nil {
+				log.Printf("%s: ERROR: %v", temp.Path, err)
+				failed++
+				continue
+			}
+			log.Printf("%s: removed, %s", temp.Path, temp.Reason)
+			removed++
+		}
+	}
+	log.Printf("Removed %d of %d temporary file(s).", removed, len(temps))
+	if failed > 0 {
+		return fmt.Errorf("unable to remove %d temporary file(s)", failed)
+	}
+	return nil
+}
+
+// historyResult holds the records of a file, as printed by
+// "history --json".
+type historyResult struct {
+	File    string   `json:"file"`
+	Records []record `json:"records"`
+}
+
+// historyCommand implements "videofix history": show the records of the
+// files in the state file.
+func historyCommand(ctx context.Context, args []string) error {
+	fs := newFlagSet("history")
+	state := fs.String("state", defaultStateFile(), "State file recording the fixed files")
+	asJSON := fs.Bool("json", false, "Output in JSON format")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	db, err := loadState(*state)
+	if err != nil {
+		return err
+	}
+
+	var results []historyResult
+	for _, file := range fs.Args() {
+		results = append(results, historyResult{File: file, Records: db.history(file)})
+	}
+	if *asJSON {
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	for i, result := range results {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Println(result.File)
+		if len(result.Records) == 0 {
+			fmt.Println("  No records.")
+			continue
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "  TIME\tOUTCOME\tSIZE\tSETTINGS\tOUTPUT\tREASON")
+		for _, r := range result.Records {
+			fmt.Fprintf(w, "  %s\t%s\t%d\t%s\t%s\t%s\n", r.Time.Local().Format(time.DateTime), r.Outcome, r.Size, r.Settings, dash(r.Output), dash(r.Reason))
+		}
+		w.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestInputFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "movie", "movie.mkv"), "large movie file")
+	writeFile(t, filepath.Join(dir, "movie", "sample.mkv"), "small")
+	writeFile(t, filepath.Join(dir, "movie", "movie.nfo"), "a very large file that is not a video")
+	writeFile(t, filepath.Join(dir, "show.MP4"), "show")
+	writeFile(t, filepath.Join(dir, "empty", "readme.txt"), "readme")
+
+	testCases := []struct {
+		name      string
+		paths     []string
+		expected  []string
+		wantError bool
+	}{
+		{
+			name:     "Files",
+			paths:    []string{filepath.Join(dir, "show.MP4"), filepath.Join(dir, "movie", "sample.mkv")},
+			expected: []string{filepath.Join(dir, "show.MP4"), filepath.Join(dir, "movie", "sample.mkv")},
+		},
+		{
+			name:     "Largest file in directory",
+			paths:    []string{filepath.Join(dir, "movie")},
+			expected: []string{filepath.Join(dir, "movie", "movie.mkv")},
+		},
+		{
+			name:      "Directory without video files",
+			paths:     []string{filepath.Join(dir, "empty")},
+			wantError: true,
+		},
+		{
+			name:      "Missing file",
+			paths:     []string{filepath.Join(dir, "missing.mkv")},
+			wantError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := inputFiles(tc.paths)
+			if tc.wantError {
+				if err == nil {
+					t.Fatalf("expected error, got %v", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestScanFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a", "movie.mkv"), "movie")
+	writeFile(t, filepath.Join(dir, "a", "movie.nfo"), "info")
+	writeFile(t, filepath.Join(dir, "b", "c", "show.mp4"), "show")
+	writeFile(t, filepath.Join(dir, "top.mkv"), "top")
+
+	expected := []string{
+		filepath.Join(dir, "a", "movie.mkv"),
+		filepath.Join(dir, "b", "c", "show.mp4"),
+		filepath.Join(dir, "top.mkv"),
+	}
+	result, err := scanFiles([]string{dir})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+}
@@ -0,0 +1,220 @@
+// Configuration files.
+//
+// Settings can be read from a user configuration file
+// (~/.config/videofix/config.yaml) and from .videofix.yaml files in the
+// directory of the input file and its parents. Keys are the names of the
+// command line flags, e.g.:
+//
+//	lang: jpn
+//	prune: true
+//	transcode: [E-AC-3, DTS]
+//
+// The user configuration is applied first, followed by the per-directory
+// files from the outermost to the innermost directory. Flags given in the
+// command line always win. Settings that run external programs (tool paths
+// and hooks) are only accepted in the user configuration file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const dirConfigName = ".videofix.yaml"
+
+// nonConfigFlags lists the flags that can't be set in configuration files.
+var nonConfigFlags = map[string]bool{
+	"config":      true,
+	"dir":         true,
+	"input":       true,
+	"show-config": true,
+}
+
+// userOnlyFlags lists the flags that run external programs. They can only be
+// set in the user configuration file and the command line, never in
+// per-directory files (which anyone with write access to a media directory
+// could create) or in serve job requests.
+var userOnlyFlags = map[string]bool{
+	"ffmpeg":    true,
+	"ffprobe":   true,
+	"mkvmerge":  true,
+	"post-hook": true,
+	"pre-hook":  true,
+}
+
+// setting holds the value of a flag and where it came from.
+type setting struct {
+	value  string
+	source string
+}
+
+// userConfigFile returns the path to the user configuration file.
+func userConfigFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "videofix", "config.yaml")
+}
+
+// dirConfigFiles returns the per-directory configuration files that apply
+// to path, from the outermost to the innermost directory.
+func dirConfigFiles(path string) []string {
+	dir, err := filepath.Abs(path)
+	if err != nil {
+		return nil
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		dir = filepath.Dir(dir)
+	}
+
+	var ret []string
+	for {
+		cfg := filepath.Join(dir, dirConfigName)
+		if _, err := os.Stat(cfg); err == nil {
+			ret = append([]string{cfg}, ret...)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return ret
+}
+
+// readConfigFile reads a YAML configuration file and returns the flag values
+// in it (see flagValues).
+func readConfigFile(fs *flag.FlagSet, path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	ret, err := flagValues(fs, raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return ret, nil
+}
+
+// flagValues converts the settings in raw (from a configuration file or a
+// job) to flag values. Lists are converted to comma separated values.
+// Unknown keys are reported as errors.
+func flagValues(fs *flag.FlagSet, raw map[string]any) (map[string]string, error) {
+	ret := map[string]string{}
+	for key, value := range raw {
+		if fs.Lookup(key) == nil || nonConfigFlags[key] {
+			return nil, fmt.Errorf("unknown setting %q", key)
+		}
+		switch v := value.(type) {
+		case []any:
+			var items []string
+			for _, item := range v {
+				items = append(items, fmt.Sprint(item))
+			}
+			ret[key] = strings.Join(items, ",")
+		case nil:
+			ret[key] = ""
+		default:
+			ret[key] = fmt.Sprint(v)
+		}
+	}
+	return ret, nil
+}
+
+// loadConfig reads the user configuration file (if it exists) and all
+// per-directory configuration files applying to path, and returns the
+// resulting settings. Later files override earlier ones. Settings listed in
+// userOnlyFlags are refused in per-directory files.
+func loadConfig(fs *flag.FlagSet, userConfig string, path string) (map[string]setting, error) {
+	var files []string
+	if userConfig != "" {
+		if _, err := os.Stat(userConfig); err == nil {
+			files = append(files, userConfig)
+		}
+	}
+	files = append(files, dirConfigFiles(path)...)
+
+	ret := map[string]setting{}
+	for _, file := range files {
+		values, err := readConfigFile(fs, file)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range values {
+			if userOnlyFlags[key] && file != userConfig {
+				return nil, fmt.Errorf("%s: setting %q is only allowed in the user configuration file", file, key)
+			}
+			ret[key] = setting{value: value, source: file}
+		}
+	}
+	return ret, nil
+}
+
+// cmdlineFlags returns the names of the flags set in the command line. It
+// must be called before applyConfig.
+func cmdlineFlags(fs *flag.FlagSet) map[string]bool {
+	ret := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { ret[f.Name] = true })
+	return ret
+}
+
+// applyConfig sets the flags from the configuration settings, except for
+// the flags explicitly set in the command line.
+func applyConfig(fs *flag.FlagSet, settings map[string]setting) error {
+	cmdline := cmdlineFlags(fs)
+	for name, s := range settings {
+		if cmdline[name] {
+			continue
+		}
+		if err := fs.Set(name, s.value); err != nil {
+			return fmt.Errorf("%s: invalid value for %q: %w", s.source, name, err)
+		}
+	}
+	return nil
+}
+
+// showConfig prints the effective value of all settings in YAML format,
+// with the source of each value as a comment. Cmdline holds the flags set
+// in the command line.
+func showConfig(fs *flag.FlagSet, settings map[string]setting, cmdline map[string]bool) {
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) {
+		if !nonConfigFlags[f.Name] {
+			names = append(names, f.Name)
+		}
+	})
+	sort.Strings(names)
+
+	for _, name := range names {
+		source := "default"
+		switch {
+		case cmdline[name]:
+			source = "command line"
+		case settings[name].source != "":
+			source = settings[name].source
+		}
+		// Keep booleans and numbers unquoted.
+		var v any = fs.Lookup(name).Value.String()
+		if getter, ok := fs.Lookup(name).Value.(flag.Getter); ok {
+			switch typed := getter.Get().(type) {
+			case bool, int, float64:
+				v = typed
+			}
+		}
+		value, _ := yaml.Marshal(v)
+		fmt.Printf("%s: %s  # %s\n", name, strings.TrimSpace(string(value)), source)
+	}
+}
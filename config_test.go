@@ -0,0 +1,158 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testFlagSet returns a flag set with a few flags for configuration tests.
+func testFlagSet() *flag.FlagSet {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("lang", "eng", "")
+	fs.Bool("prune", false, "")
+	fs.String("transcode", "E-AC-3", "")
+	fs.String("input", "", "")
+	fs.String("pre-hook", "", "")
+	fs.String("post-hook", "", "")
+	fs.String("ffmpeg", "", "")
+	fs.String("ffprobe", "", "")
+	fs.String("mkvmerge", "", "")
+	return fs
+}
+
+func writeFile(t *testing.T, path string, data string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadConfigFile(t *testing.T) {
+	dir := t.TempDir()
+
+	testCases := []struct {
+		name      string
+		data      string
+		expected  map[string]string
+		expectErr bool
+	}{
+		{
+			name:     "Scalars and lists",
+			data:     "lang: jpn\nprune: true\ntranscode: [E-AC-3, DTS]\n",
+			expected: map[string]string{"lang": "jpn", "prune": "true", "transcode": "E-AC-3,DTS"},
+		},
+		{
+			name:      "Unknown setting",
+			data:      "language: jpn\n",
+			expectErr: true,
+		},
+		{
+			name:      "Command line only setting",
+			data:      "input: foo.mkv\n",
+			expectErr: true,
+		},
+		{
+			name:      "Invalid YAML",
+			data:      "lang: [jpn\n",
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(dir, "config.yaml")
+			writeFile(t, path, tc.data)
+
+			result, err := readConfigFile(testFlagSet(), path)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("expected error, but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("expected:\n%v\ngot:\n%v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestLoadAndApplyConfig(t *testing.T) {
+	dir := t.TempDir()
+	userConfig := filepath.Join(dir, "config.yaml")
+	writeFile(t, userConfig, "lang: eng\nprune: true\ntranscode: [E-AC-3, DTS]\n")
+	writeFile(t, filepath.Join(dir, "library", dirConfigName), "transcode: DTS\n")
+	writeFile(t, filepath.Join(dir, "library", "anime", dirConfigName), "lang: jpn\n")
+	movie := filepath.Join(dir, "library", "anime", "show", "episode.mkv")
+	writeFile(t, movie, "")
+
+	settings, err := loadConfig(testFlagSet(), userConfig, movie)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]setting{
+		"lang":      {value: "jpn", source: filepath.Join(dir, "library", "anime", dirConfigName)},
+		"prune":     {value: "true", source: userConfig},
+		"transcode": {value: "DTS", source: filepath.Join(dir, "library", dirConfigName)},
+	}
+	if !reflect.DeepEqual(settings, expected) {
+		t.Fatalf("expected:\n%v\ngot:\n%v", expected, settings)
+	}
+
+	// Command line flags win over configuration files.
+	fs := testFlagSet()
+	if err := fs.Parse([]string{"--lang", "por"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfig(fs, settings); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for name, want := range map[string]string{"lang": "por", "prune": "true", "transcode": "DTS"} {
+		if got := fs.Lookup(name).Value.String(); got != want {
+			t.Errorf("%s: expected %q, got %q", name, want, got)
+		}
+	}
+}
+
+func TestLoadConfigUserOnly(t *testing.T) {
+	for _, name := range []string{"pre-hook", "post-hook", "ffmpeg", "ffprobe", "mkvmerge"} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			userConfig := filepath.Join(dir, "config.yaml")
+			writeFile(t, userConfig, name+": notify\n")
+			movie := filepath.Join(dir, "library", "movie.mkv")
+			writeFile(t, movie, "")
+
+			// Allowed in the user configuration file.
+			settings, err := loadConfig(testFlagSet(), userConfig, movie)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := settings[name].value; got != "notify" {
+				t.Errorf("expected %q, got %q", "notify", got)
+			}
+
+			// Refused in per-directory files.
+			writeFile(t, filepath.Join(dir, "library", dirConfigName), name+": touch /tmp/pwned\n")
+			if _, err := loadConfig(testFlagSet(), userConfig, movie); err == nil {
+				t.Errorf("expected error, but got none")
+			}
+		})
+	}
+}
+
+func TestApplyConfigInvalidValue(t *testing.T) {
+	settings := map[string]setting{"prune": {value: "maybe", source: "config.yaml"}}
+	if err := applyConfig(testFlagSet(), settings); err == nil {
+		t.Errorf("expected error, but got none")
+	}
+}
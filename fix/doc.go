@@ -0,0 +1,38 @@
+// Package fix fixes common problems in MKV files:
+//
+//   - Convert EAC3 audio to AAC to avoid issues with players.
+//   - Keep only the main video track, dropping cover art.
+//   - Optionally re-encode video tracks in codecs unsupported by players.
+//   - If the file has equivalent EAC3/AAC tracks, remove the EAC3 version.
+//   - Set all tracks in the default language to be the default tracks.
+//   - All other tracks and metadata is copied from the original file, unless
+//     global tag and chapter editing is requested.
+//
+// Fixing a file takes three steps: Probe reads the tracks of the input file
+// (mkvmerge and ffprobe), NewPlan decides what to do with each track given
+// the Options, and Execute runs ffmpeg to write the output file:
+//
+//	opts := fix.DefaultOptions()
+//	tracks, err := fix.Probe(ctx, "movie.mkv", opts)
+//	if err != nil {
+//		return err
+//	}
+//	plan, err := fix.NewPlan(ctx, tracks, opts)
+//	if err != nil {
+//		return err
+//	}
+//	return fix.Execute(ctx, plan)
+//
+// A Plan lists what happens to each track (TrackPlan) and why. Plans can be
+// inspected or modified before calling Execute, and serialized to JSON. The
+// ffmpeg command line is generated from the plan by Plan.Command. Use
+// ExecuteProgress instead of Execute to receive the progress of ffmpeg
+// (position, percentage, speed and ETA).
+//
+// Use CheckRequirements to make sure mkvmerge, ffmpeg and ffprobe are
+// installed, or ProbeTools to also find their versions and the encoders
+// available in ffmpeg (see Capabilities.CheckOptions). Each program is
+// searched, in order, in the path set in Options.ToolPaths, the
+// VIDEOFIX_MKVMERGE, VIDEOFIX_FFMPEG and VIDEOFIX_FFPROBE environment
+// variables, the directory of the running executable and the PATH.
+package fix
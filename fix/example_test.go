@@ -0,0 +1,51 @@
+package fix_test
+
+import (
+	"context"
+	"log"
+
+	"github.com/marcopaganini/videofix/fix"
+)
+
+func Example() {
+	ctx := context.Background()
+	opts := fix.DefaultOptions()
+	tracks, err := fix.Probe(ctx, "movie.mkv", opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	plan, err := fix.NewPlan(ctx, tracks, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := fix.Execute(ctx, plan); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func ExampleNewPlan() {
+	ctx := context.Background()
+	opts := fix.DefaultOptions()
+	opts.Lang = "jpn"
+	opts.Prune = true
+	opts.Transcode = []string{"E-AC-3", "DTS"}
+	opts.DryRun = true
+
+	tracks, err := fix.Probe(ctx, "movie.mkv", opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Import subtitles and audio from files like movie.en.srt.
+	sidecars, err := fix.ProbeSidecars(ctx, "movie.mkv", opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	plan, err := fix.NewPlan(ctx, append(tracks, sidecars...), opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, tp := range plan.Tracks {
+		log.Printf("%s track %d: %s (%s)", tp.Track.Type, tp.Track.ID, tp.Action, tp.Reason)
+	}
+	log.Printf("Command: %q", plan.Command())
+}
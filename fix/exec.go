@@ -0,0 +1,68 @@
+// Running external programs.
+//
+// All programs run under a context. Probes (mkvmerge and ffprobe) have a
+// fixed timeout, while the ffmpeg timeout (including the verification of the
+// output) is scaled to the duration of the input file (see Plan.Timeout).
+// Timed out and cancelled runs return errors wrapping ErrTimeout and
+// ErrCancelled.
+
+package fix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// probeTimeout is the maximum time to run mkvmerge and ffprobe.
+	probeTimeout = 2 * time.Minute
+	// minTimeout is added to the ffmpeg timeout, for short files.
+	minTimeout = 5 * time.Minute
+	// reencodeTimeoutFactor multiplies the ffmpeg timeout when re-encoding
+	// video, which is much slower than copying.
+	reencodeTimeoutFactor = 10
+)
+
+var (
+	// ErrTimeout is returned when an external program takes too long.
+	ErrTimeout = errors.New("timed out")
+	// ErrCancelled is returned when the context is cancelled (e.g. by
+	// an interrupt signal) while an external program is running.
+	ErrCancelled = errors.New("cancelled")
+)
+
+// commandError returns ErrTimeout or ErrCancelled if the command failed
+// because the context ended, or err otherwise.
+func commandError(ctx context.Context, err error) error {
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return ErrTimeout
+	case errors.Is(ctx.Err(), context.Canceled):
+		return ErrCancelled
+	}
+	return err
+}
+
+// probeOutput runs a probe command (with probeTimeout) and returns its
+// standard output.
+func probeOutput(ctx context.Context, path string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	return runOutput(ctx, path, args...)
+}
+
+// runOutput runs a command and returns its standard output.
+func runOutput(ctx context.Context, path string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, path, args...)
+	// Don't wait for orphaned children holding the output pipe.
+	cmd.WaitDelay = time.Second
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error running %s: %w", filepath.Base(path), commandError(ctx, err))
+	}
+	return output, nil
+}
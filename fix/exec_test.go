@@ -0,0 +1,107 @@
+package fix
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPlanTimeout(t *testing.T) {
+	testCases := []struct {
+		name     string
+		factor   float64
+		duration time.Duration
+		action   string
+		verify   string
+		expected time.Duration
+	}{
+		{name: "Copy", factor: 1, duration: time.Hour, action: ActionCopy, expected: time.Hour + minTimeout},
+		{name: "Re-encode", factor: 1, duration: time.Hour, action: ActionReencode, expected: 10*time.Hour + minTimeout},
+		{name: "Decode", factor: 1, duration: time.Hour, action: ActionCopy, verify: VerifyDecode, expected: 2*time.Hour + minTimeout},
+		{name: "Factor", factor: 0.5, duration: time.Hour, action: ActionCopy, expected: 30*time.Minute + minTimeout},
+		{name: "Disabled", factor: 0, duration: time.Hour, action: ActionCopy},
+		{name: "Unknown duration", factor: 1, action: ActionCopy},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			plan := Plan{
+				Options:  Options{TimeoutFactor: tc.factor, Verify: tc.verify},
+				Duration: tc.duration,
+				Tracks:   []TrackPlan{{Track: Track{Type: "video"}, Action: tc.action}},
+			}
+			if result := plan.Timeout(); result != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+// writeScript writes an executable shell script to dir and returns its path.
+func writeScript(t *testing.T, dir string, name string, script string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExecuteCancel(t *testing.T) {
+	dir := t.TempDir()
+	// The fake ffmpeg creates the output file (the last argument) and hangs.
+	ffmpeg := writeScript(t, dir, "ffmpeg", "for a; do last=$a; done\necho partial > \"$last\"\nexec sleep 60\n")
+	input := filepath.Join(dir, "movie.mkv")
+	if err := os.WriteFile(input, []byte("movie"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name     string
+		ctx      func() (context.Context, context.CancelFunc)
+		expected error
+	}{
+		{
+			name: "Timeout",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 200*time.Millisecond)
+			},
+			expected: ErrTimeout,
+		},
+		{
+			name: "Cancelled",
+			ctx: func() (context.Context, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(200*time.Millisecond, cancel)
+				return ctx, cancel
+			},
+			expected: ErrCancelled,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.FFmpeg = ffmpeg
+			plan := Plan{
+				Input:    input,
+				Output:   input,
+				TempFile: filepath.Join(dir, "movie_with_aac.mkv.TMP"),
+				Options:  opts,
+			}
+			ctx, cancel := tc.ctx()
+			defer cancel()
+
+			err := Execute(ctx, plan)
+			if !errors.Is(err, tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, err)
+			}
+			if _, err := os.Stat(plan.TempFile); err == nil {
+				t.Errorf("partial output %s was not removed", plan.TempFile)
+			}
+		})
+	}
+}
@@ -0,0 +1,261 @@
+// Track probing and selection.
+
+package fix
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	outputSuffix = "_with_aac"
+	eac3Codec    = "E-AC-3"
+	aacCodec     = "AAC"
+	aacBitrate   = "256k"
+	mkvAudioType = "audio"
+	mkvSubType   = "subtitles"
+)
+
+// TrackProperties holds the track properties reported by mkvmerge.
+type TrackProperties struct {
+	Language            string `json:"language"`
+	CodecID             string `json:"codec_id"`
+	DefaultTrack        bool   `json:"default_track"`
+	ForcedTrack         bool   `json:"forced_track"`
+	FlagCommentary      bool   `json:"flag_commentary"`
+	FlagHearingImpaired bool   `json:"flag_hearing_impaired"`
+	TrackName           string `json:"track_name"`
+	AudioChannels       int    `json:"audio_channels"`
+	PixelDimensions     string `json:"pixel_dimensions"`
+	CodecPrivateData    string `json:"codec_private_data"`
+}
+
+// Track holds information about a track from mkvmerge.
+type Track struct {
+	ID         int             `json:"id"`
+	Type       string          `json:"type"`
+	CodecID    string          `json:"codec"`
+	Properties TrackProperties `json:"properties"`
+	// File is the file containing this track and Sidecar tells if
+	// it is a sidecar file instead of the main input file.
+	File    string `json:"file,omitempty"`
+	Sidecar bool   `json:"sidecar,omitempty"`
+	// HDR holds the HDR formats of video tracks (from ffprobe).
+	HDR HDRInfo `json:"hdr"`
+	// Duration is the duration of the file containing this track, or zero
+	// if unknown.
+	Duration time.Duration `json:"duration,omitempty"`
+}
+
+// mkvInfo holds the top-level JSON structure from mkvmerge.
+type mkvInfo struct {
+	Container struct {
+		Properties struct {
+			Duration int64 `json:"duration"` // Nanoseconds.
+		} `json:"properties"`
+	} `json:"container"`
+	Tracks []Track `json:"tracks"`
+}
+
+// Probe returns all tracks in the input file using mkvmerge --identify.
+// HDR information for video tracks comes from ffprobe. Only the paths to
+// the external programs are used from the options.
+func Probe(ctx context.Context, inputFile string, opts Options) ([]Track, error) {
+	// Check if the input file exists
+	if _, err := os.Stat(inputFile); os.IsNotExist(err) {
+		return nil, fmt.Errorf("file not found: %s", inputFile)
+	}
+
+	// Get track information using mkvmerge.
+	output, err := probeOutput(ctx, opts.path("mkvmerge"), "--identify", "-F", "json", inputFile)
+	if err != nil {
+		return []Track{}, err
+	}
+
+	var info mkvInfo
+	if err := json.Unmarshal(output, &info); err != nil {
+		return []Track{}, fmt.Errorf("error parsing mkvmerge JSON output: %w", err)
+	}
+
+	tracks := []Track{}
+	for _, track := range info.Tracks {
+		t := Track{
+			ID:         track.ID,
+			Type:       track.Type,
+			CodecID:    track.CodecID,
+			Properties: track.Properties,
+			File:       inputFile,
+			Duration:   time.Duration(info.Container.Properties.Duration),
+		}
+		tracks = append(tracks, t)
+	}
+
+	if len(filterTracks(tracks, mkvVideoType, "", "")) == 0 {
+		return tracks, nil
+	}
+	hdr, err := readHDRInfo(ctx, inputFile, opts.path("ffprobe"))
+	if err != nil {
+		return []Track{}, err
+	}
+	for i := range tracks {
+		tracks[i].HDR = hdr[tracks[i].ID]
+	}
+
+	return tracks, nil
+}
+
+// filterTracks returns a list of tracks filtered by type, codec and language. If any of the
+// parameters is blank, ignore it during comparison.
+func filterTracks(tracks []Track, ttype string, codec string, lang string) []Track {
+	var ret []Track
+	for _, track := range tracks {
+		if ttype != "" && track.Type != ttype {
+			continue
+		}
+		if codec != "" && track.CodecID != codec {
+			continue
+		}
+		if lang != "" && track.Properties.Language != lang {
+			continue
+		}
+		ret = append(ret, track)
+	}
+	return ret
+}
+
+// pruneOK returns checks if pruning would remove all tracks of a given type
+// and language from the output (E.g, resulting in a file with no audio
+// tracks).  Returns nil or an error.
+func pruneOK(tracks []Track, lang string) error {
+	// Filter all output tracks using the default language.
+	var filteredTracks []Track
+	for _, t := range tracks {
+		if t.Properties.Language == lang || t.Properties.Language == "und" {
+			filteredTracks = append(filteredTracks, t)
+		}
+	}
+	// Make sure we will still have at least one of each input track type and
+	// language in the output.
+	inputTrackTypes := make(map[string]bool)
+	for _, t := range tracks {
+		inputTrackTypes[t.Type] = true
+	}
+	outputTrackTypes := make(map[string]bool)
+	for _, t := range filteredTracks {
+		outputTrackTypes[t.Type] = true
+	}
+	for trackType := range inputTrackTypes {
+		if !outputTrackTypes[trackType] {
+			return fmt.Errorf("pruning would remove all %s tracks from the output", trackType)
+		}
+	}
+	return nil
+}
+
+// mustTranscode returns true if the audio track uses one of the codecs
+// selected for conversion to AAC.
+func mustTranscode(track Track, codecs []string) bool {
+	for _, codec := range codecs {
+		if codec == track.CodecID {
+			return true
+		}
+	}
+	return false
+}
+
+// langAndDisposition returns the language and the ffmpeg disposition string
+// for the track. Tracks in the default language get the default flag and
+// the forced, commentary and hearing impaired flags are preserved.
+func langAndDisposition(track Track, defaultLang string) (string, string) {
+	lang := "und"
+	disposition := "-default"
+
+	if track.Properties.Language != "" {
+		lang = track.Properties.Language
+	}
+	if lang == defaultLang {
+		disposition = "default"
+	}
+	return lang, disposition + dispositionFlags(track)
+}
+
+// dispositionFlags returns the ffmpeg disposition flags to preserve the
+// forced, commentary and hearing impaired flags of the track (e.g.
+// "+forced+comment"), to be appended to the default flag.
+func dispositionFlags(track Track) string {
+	var ret string
+	if track.Properties.ForcedTrack {
+		ret += "+forced"
+	}
+	if track.Properties.FlagCommentary {
+		ret += "+comment"
+	}
+	if track.Properties.FlagHearingImpaired {
+		ret += "+hearing_impaired"
+	}
+	return ret
+}
+
+// planAudio decides what to do with the audio tracks. Tracks using one of
+// the selected codecs are converted to AAC, unless an equivalent AAC track
+// exists. Other tracks are copied.
+func planAudio(tracks []Track, opts Options) []TrackPlan {
+	var ret []TrackPlan
+	for _, track := range filterTracks(tracks, mkvAudioType, "", "") {
+		lang, disposition := langAndDisposition(track, opts.Lang)
+		tp := TrackPlan{Track: track, Action: ActionCopy, Language: lang, Disposition: disposition}
+		equivalent := filterTracks(tracks, mkvAudioType, aacCodec, lang)
+
+		switch {
+		// If pruning is enabled, skip tracks that are not in the default language or "und".
+		case opts.Prune && lang != opts.Lang && lang != "und":
+			tp.Action, tp.Reason = ActionDrop, "not in the default language (--prune)"
+		case !mustTranscode(track, opts.Transcode):
+			tp.Reason = "codec not selected for conversion"
+		// If we have an equivalent AAC track with the same language and
+		// language is not "und", ignore the track to be converted.
+		case lang != "und" && len(equivalent) > 0:
+			tp.Action, tp.Reason = ActionDrop, fmt.Sprintf("found %d AAC equivalent audio track(s)", len(equivalent))
+		default:
+			tp.Action, tp.Codec = ActionTranscode, aacCodec
+			tp.Reason = fmt.Sprintf("%s --> AAC conversion", shortCodecName(track.CodecID))
+			if opts.Titles == TitlesOff {
+				tp.Title = fmt.Sprintf("AAC Audio (%s)", lang)
+			}
+		}
+
+		if tp.Action != ActionDrop {
+			outCodec := track.CodecID
+			if tp.Codec != "" {
+				outCodec = tp.Codec
+			}
+			if title, ok := trackTitle(track, outCodec, outCodec != track.CodecID, opts.Titles, opts.TitleTemplate); ok {
+				tp.Title = title
+			}
+		}
+		ret = append(ret, tp)
+	}
+	return ret
+}
+
+// planSubtitles decides what to do with the subtitle tracks. All subtitle
+// tracks are copied.
+func planSubtitles(tracks []Track, opts Options) []TrackPlan {
+	var ret []TrackPlan
+	for _, track := range filterTracks(tracks, mkvSubType, "", "") {
+		lang, disposition := langAndDisposition(track, opts.Lang)
+		tp := TrackPlan{Track: track, Action: ActionCopy, Language: lang, Disposition: disposition, Reason: "subtitle track"}
+
+		// If pruning is enabled, skip tracks that are not in the default language or "und".
+		if opts.Prune && lang != opts.Lang && lang != "und" {
+			tp.Action, tp.Reason = ActionDrop, "not in the default language (--prune)"
+		} else if title, ok := trackTitle(track, track.CodecID, false, opts.Titles, opts.TitleTemplate); ok {
+			tp.Title = title
+		}
+		ret = append(ret, tp)
+	}
+	return ret
+}
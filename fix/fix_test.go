@@ -0,0 +1,336 @@
+package fix
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFilterTracks(t *testing.T) {
+	tracks := []Track{
+		{ID: 1, Type: "audio", CodecID: "AAC", Properties: TrackProperties{Language: "eng"}},
+		{ID: 2, Type: "audio", CodecID: "E-AC-3", Properties: TrackProperties{Language: "eng"}},
+		{ID: 3, Type: "video", CodecID: "V_MPEG4/ISO/AVC", Properties: TrackProperties{Language: "und"}},
+		{ID: 4, Type: "subtitles", CodecID: "S_HDMV/PGS", Properties: TrackProperties{Language: "eng"}},
+		{ID: 5, Type: "audio", CodecID: "AAC", Properties: TrackProperties{Language: "spa"}},
+	}
+
+	testCases := []struct {
+		name     string
+		ttype    string
+		codec    string
+		lang     string
+		expected []Track
+	}{
+		{
+			name:  "Filter by ttype audio",
+			ttype: "audio",
+			expected: []Track{
+				{ID: 1, Type: "audio", CodecID: "AAC", Properties: TrackProperties{Language: "eng"}},
+				{ID: 2, Type: "audio", CodecID: "E-AC-3", Properties: TrackProperties{Language: "eng"}},
+				{ID: 5, Type: "audio", CodecID: "AAC", Properties: TrackProperties{Language: "spa"}},
+			},
+		},
+		{
+			name:  "Filter by codec AAC",
+			codec: "AAC",
+			expected: []Track{
+				{ID: 1, Type: "audio", CodecID: "AAC", Properties: TrackProperties{Language: "eng"}},
+				{ID: 5, Type: "audio", CodecID: "AAC", Properties: TrackProperties{Language: "spa"}},
+			},
+		},
+		{
+			name: "Filter by lang eng",
+			lang: "eng",
+			expected: []Track{
+				{ID: 1, Type: "audio", CodecID: "AAC", Properties: TrackProperties{Language: "eng"}},
+				{ID: 2, Type: "audio", CodecID: "E-AC-3", Properties: TrackProperties{Language: "eng"}},
+				{ID: 4, Type: "subtitles", CodecID: "S_HDMV/PGS", Properties: TrackProperties{Language: "eng"}},
+			},
+		},
+		{
+			name:  "Filter by ttype audio and lang eng",
+			ttype: "audio",
+			lang:  "eng",
+			expected: []Track{
+				{ID: 1, Type: "audio", CodecID: "AAC", Properties: TrackProperties{Language: "eng"}},
+				{ID: 2, Type: "audio", CodecID: "E-AC-3", Properties: TrackProperties{Language: "eng"}},
+			},
+		},
+		{
+			name:  "Filter by ttype audio, codec AAC, and lang eng",
+			ttype: "audio",
+			codec: "AAC",
+			lang:  "eng",
+			expected: []Track{
+				{ID: 1, Type: "audio", CodecID: "AAC", Properties: TrackProperties{Language: "eng"}},
+			},
+		},
+		{
+			name:     "No matching tracks",
+			ttype:    "video",
+			lang:     "spa",
+			expected: []Track{},
+		},
+		{
+			name:     "Empty filters",
+			expected: tracks,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := filterTracks(tracks, tc.ttype, tc.codec, tc.lang)
+			if len(result) == 0 && len(tc.expected) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("expected:\n%v\ngot:\n%v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestPruneOK(t *testing.T) {
+	tracks := []Track{
+		{ID: 1, Type: "audio", CodecID: "AAC", Properties: TrackProperties{Language: "eng"}},
+		{ID: 2, Type: "audio", CodecID: "E-AC-3", Properties: TrackProperties{Language: "por"}},
+		{ID: 3, Type: "video", CodecID: "V_MPEG4/ISO/AVC", Properties: TrackProperties{Language: "und"}},
+		{ID: 4, Type: "subtitles", CodecID: "S_HDMV/PGS", Properties: TrackProperties{Language: "eng"}},
+		{ID: 5, Type: "subtitles", CodecID: "S_HDMV/PGS", Properties: TrackProperties{Language: "por"}},
+	}
+
+	testCases := []struct {
+		name          string
+		tracks        []Track
+		defaultLang   string
+		expectErr     bool
+		expectedError string
+	}{
+		{
+			name:          "successful pruning",
+			tracks:        tracks,
+			defaultLang:   "eng",
+			expectedError: "",
+		},
+		{
+			name: "Pruning would remove all audio tracks",
+			tracks: []Track{
+				{ID: 1, Type: "audio", CodecID: "AAC", Properties: TrackProperties{Language: "spa"}},
+				{ID: 2, Type: "video", CodecID: "V_MPEG4/ISO/AVC", Properties: TrackProperties{Language: "und"}},
+			},
+			defaultLang:   "eng",
+			expectErr:     true,
+			expectedError: "pruning would remove all audio tracks from the output",
+		},
+		{
+			name:        "No tracks pruned",
+			tracks:      tracks,
+			defaultLang: "por",
+			expectErr:   false,
+		},
+		{
+			name:        "Empty track list",
+			tracks:      []Track{},
+			defaultLang: "eng",
+			expectErr:   false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := pruneOK(tc.tracks, tc.defaultLang)
+
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("expected error, but got none")
+				}
+				if !strings.Contains(err.Error(), tc.expectedError) {
+					t.Fatalf("expected error '%s', but got '%s'", tc.expectedError, err.Error())
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// decisions returns the track plans as "type ID: action" strings.
+func decisions(plans []TrackPlan) []string {
+	var ret []string
+	for _, tp := range plans {
+		ret = append(ret, fmt.Sprintf("%s %d: %s", tp.Track.Type, tp.Track.ID, tp.Action))
+	}
+	return ret
+}
+
+func TestPlanAudio(t *testing.T) {
+	tracks := []Track{
+		{ID: 1, Type: "audio", CodecID: "E-AC-3", Properties: TrackProperties{Language: "eng"}},
+		{ID: 2, Type: "audio", CodecID: "AAC", Properties: TrackProperties{Language: "eng"}},
+		{ID: 3, Type: "video", CodecID: "V_MPEG4/ISO/AVC", Properties: TrackProperties{Language: ""}},
+		{ID: 4, Type: "subtitles", CodecID: "S_HDMV/PGS", Properties: TrackProperties{Language: "eng"}},
+		{ID: 5, Type: "audio", CodecID: "E-AC-3", Properties: TrackProperties{Language: "spa"}},
+		{ID: 6, Type: "audio", CodecID: "DTS", Properties: TrackProperties{Language: "und"}},
+	}
+
+	testCases := []struct {
+		name      string
+		doPrune   bool
+		transcode []string
+		expected  []string
+	}{
+		{
+			name:      "EAC3 to AAC conversion",
+			transcode: []string{"E-AC-3"},
+			expected:  []string{"audio 1: drop", "audio 2: copy", "audio 5: transcode", "audio 6: copy"},
+		},
+		{
+			name:      "Pruning enabled",
+			doPrune:   true,
+			transcode: []string{"E-AC-3"},
+			expected:  []string{"audio 1: drop", "audio 2: copy", "audio 5: drop", "audio 6: copy"},
+		},
+		{
+			name:      "Transcode DTS",
+			transcode: []string{"DTS"},
+			expected:  []string{"audio 1: copy", "audio 2: copy", "audio 5: copy", "audio 6: transcode"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.Prune = tc.doPrune
+			opts.Transcode = tc.transcode
+			result := decisions(planAudio(tracks, opts))
+			if !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("expected:\n%v\ngot:\n%v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestPlanCommand(t *testing.T) {
+	tracks := []Track{
+		{ID: 1, Type: "audio", CodecID: "E-AC-3", Properties: TrackProperties{Language: "eng"}, File: "input.mkv"},
+		{ID: 2, Type: "audio", CodecID: "AAC", Properties: TrackProperties{Language: "eng"}, File: "input.mkv"},
+		{ID: 3, Type: "video", CodecID: "V_MPEG4/ISO/AVC", Properties: TrackProperties{Language: "und"}, File: "input.mkv"},
+		{ID: 4, Type: "subtitles", CodecID: "S_HDMV/PGS", Properties: TrackProperties{Language: "eng"}, File: "input.mkv"},
+		{ID: 5, Type: "audio", CodecID: "E-AC-3", Properties: TrackProperties{Language: "spa"}, File: "input.mkv"},
+	}
+
+	testCases := []struct {
+		name     string
+		doPrune  bool
+		optlang  string
+		expected []string
+	}{
+		{
+			name:    "EAC3 to AAC conversion",
+			doPrune: false,
+			optlang: "eng",
+			expected: []string{
+				"ffmpeg", "-loglevel", "error", "-nostats", "-progress", "pipe:1", "-i", "input.mkv",
+				"-map_chapters", "0", "-map_metadata", "0",
+				"-map", "0:3", "-c:v:0", "copy", "-disposition:v:0", "default",
+				"-map", "0:2", "-c:a:0", "copy", "-disposition:a:0", "default",
+				"-map", "0:5", "-c:a:1", "aac", "-b:a:1", "256k", "-disposition:a:1", "-default", "-metadata:s:a:1", "title=AAC Audio (spa)",
+				"-map", "0:4", "-c:s:0", "copy", "-disposition:s:0", "default",
+				"-max_interleave_delta", "0", "-y", "-f", "matroska", "input_with_aac.mkv.TMP",
+			},
+		},
+		{
+			name:    "Pruning enabled",
+			doPrune: true,
+			optlang: "eng",
+			expected: []string{
+				"ffmpeg", "-loglevel", "error", "-nostats", "-progress", "pipe:1", "-i", "input.mkv",
+				"-map_chapters", "0", "-map_metadata", "0",
+				"-map", "0:3", "-c:v:0", "copy", "-disposition:v:0", "default",
+				"-map", "0:2", "-c:a:0", "copy", "-disposition:a:0", "default",
+				"-map", "0:4", "-c:s:0", "copy", "-disposition:s:0", "default",
+				"-max_interleave_delta", "0", "-y", "-f", "matroska", "input_with_aac.mkv.TMP",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.Prune = tc.doPrune
+			opts.Lang = tc.optlang
+			plan, err := NewPlan(context.Background(), tracks, opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			result := plan.Command()
+			if !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("expected:\n%v\ngot:\n%v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestLangAndDisposition(t *testing.T) {
+	testCases := []struct {
+		name                string
+		track               Track
+		optLang             string
+		expectedLang        string
+		expectedDisposition string
+	}{
+		{
+			name:                "Language matches optLang",
+			track:               Track{Properties: TrackProperties{Language: "eng"}},
+			optLang:             "eng",
+			expectedLang:        "eng",
+			expectedDisposition: "default",
+		},
+		{
+			name:                "Language does not match optLang",
+			track:               Track{Properties: TrackProperties{Language: "spa"}},
+			optLang:             "eng",
+			expectedLang:        "spa",
+			expectedDisposition: "-default",
+		},
+		{
+			name:                "Empty language property",
+			track:               Track{Properties: TrackProperties{Language: ""}},
+			optLang:             "eng",
+			expectedLang:        "und",
+			expectedDisposition: "-default",
+		},
+		{
+			name:                "Language is und",
+			track:               Track{Properties: TrackProperties{Language: "und"}},
+			optLang:             "eng",
+			expectedLang:        "und",
+			expectedDisposition: "-default",
+		},
+		{
+			name:                "optLang is not default",
+			track:               Track{Properties: TrackProperties{Language: "por"}},
+			optLang:             "por",
+			expectedLang:        "por",
+			expectedDisposition: "default",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			lang, disposition := langAndDisposition(tc.track, tc.optLang)
+			if lang != tc.expectedLang {
+				t.Errorf("expected lang %s, got %s", tc.expectedLang, lang)
+			}
+			if disposition != tc.expectedDisposition {
+				t.Errorf("expected disposition %s, got %s", tc.expectedDisposition, disposition)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+// HDR and Dolby Vision detection.
+//
+// mkvmerge doesn't report HDR metadata, so ffprobe is used to read the
+// transfer characteristics and the Dolby Vision configuration record from
+// the video streams, and the HDR10+ dynamic metadata from their first frame.
+
+package fix
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Values for Options.HDRPolicy.
+const (
+	HDRPolicyWarn   = "warn"
+	HDRPolicyRefuse = "refuse"
+)
+
+// HDRInfo holds the HDR formats found in a video track.
+type HDRInfo struct {
+	HDR10       bool `json:"hdr10,omitempty"`
+	HDR10Plus   bool `json:"hdr10plus,omitempty"`
+	HLG         bool `json:"hlg,omitempty"`
+	DolbyVision bool `json:"dolby_vision,omitempty"`
+	DVProfile   int  `json:"dv_profile,omitempty"`
+	DVCompatID  int  `json:"dv_compat_id,omitempty"`
+}
+
+// ffprobeSideData holds the side data fields we care about from ffprobe.
+type ffprobeSideData struct {
+	Type       string `json:"side_data_type"`
+	DVProfile  int    `json:"dv_profile"`
+	DVCompatID int    `json:"dv_bl_signal_compatibility_id"`
+}
+
+// ffprobeHDR holds the top-level JSON structure from ffprobe when called
+// with -show_streams and -show_frames.
+type ffprobeHDR struct {
+	Streams []struct {
+		Index         int               `json:"index"`
+		ColorTransfer string            `json:"color_transfer"`
+		SideData      []ffprobeSideData `json:"side_data_list"`
+	} `json:"streams"`
+	Frames []struct {
+		StreamIndex int               `json:"stream_index"`
+		SideData    []ffprobeSideData `json:"side_data_list"`
+	} `json:"frames"`
+}
+
+// String returns a human readable list of the HDR formats, or a blank
+// string for SDR tracks.
+func (h HDRInfo) String() string {
+	var formats []string
+	if h.DolbyVision {
+		formats = append(formats, fmt.Sprintf("Dolby Vision profile %d.%d", h.DVProfile, h.DVCompatID))
+	}
+	if h.HDR10Plus {
+		formats = append(formats, "HDR10+")
+	}
+	if h.HDR10 {
+		formats = append(formats, "HDR10")
+	}
+	if h.HLG {
+		formats = append(formats, "HLG")
+	}
+	return strings.Join(formats, ", ")
+}
+
+// dynamic returns true if the track carries dynamic (per scene) HDR metadata,
+// which is lost when the track is re-encoded.
+func (h HDRInfo) dynamic() bool {
+	return h.DolbyVision || h.HDR10Plus
+}
+
+// parseHDRInfo parses the JSON output of ffprobe and returns the HDR
+// information for each video stream, indexed by stream index.
+func parseHDRInfo(data []byte) (map[int]HDRInfo, error) {
+	var probe ffprobeHDR
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("error parsing ffprobe JSON output: %w", err)
+	}
+
+	ret := map[int]HDRInfo{}
+	for _, stream := range probe.Streams {
+		var h HDRInfo
+		switch stream.ColorTransfer {
+		case "smpte2084":
+			h.HDR10 = true
+		case "arib-std-b67":
+			h.HLG = true
+		}
+		for _, sd := range stream.SideData {
+			if sd.Type == "DOVI configuration record" {
+				h.DolbyVision = true
+				h.DVProfile = sd.DVProfile
+				h.DVCompatID = sd.DVCompatID
+			}
+		}
+		ret[stream.Index] = h
+	}
+	for _, frame := range probe.Frames {
+		for _, sd := range frame.SideData {
+			if strings.Contains(sd.Type, "HDR10+") {
+				h := ret[frame.StreamIndex]
+				h.HDR10Plus = true
+				ret[frame.StreamIndex] = h
+			}
+		}
+	}
+	return ret, nil
+}
+
+// readHDRInfo returns the HDR information for each video stream in the
+// input file, indexed by stream index.
+func readHDRInfo(ctx context.Context, inputFile string, ffprobe string) (map[int]HDRInfo, error) {
+	output, err := probeOutput(ctx, ffprobe,
+		"-v", "error",
+		"-select_streams", "v",
+		"-show_streams",
+		"-show_frames",
+		"-read_intervals", "%+#1",
+		"-of", "json",
+		inputFile)
+	if err != nil {
+		return nil, err
+	}
+	return parseHDRInfo(output)
+}
+
+// dvMinFFmpeg is the first ffmpeg version that writes the Dolby Vision
+// configuration record to MKV files. Older versions drop it when copying
+// the track.
+const dvMinFFmpeg = "6.0"
+
+// hdrLosses returns a list of warnings for HDR metadata that would be lost
+// in the output given the video options in use. FFmpegVersion is the
+// version of ffmpeg (blank if unknown), used to check whether copied Dolby
+// Vision tracks keep their metadata.
+func hdrLosses(tracks []Track, dropExtra bool, rule videoRule, ffmpegVersion string) []string {
+	var ret []string
+
+	mainTrack, ok := mainVideoTrack(tracks)
+	for _, track := range filterTracks(tracks, mkvVideoType, "", "") {
+		if track.HDR.String() == "" || isCoverArt(track) {
+			continue
+		}
+		// Copies and HEVC re-encodes keep the static HDR10/HLG metadata,
+		// only the dynamic metadata is lost.
+		dynamic := HDRInfo{
+			DolbyVision: track.HDR.DolbyVision,
+			DVProfile:   track.HDR.DVProfile,
+			DVCompatID:  track.HDR.DVCompatID,
+			HDR10Plus:   track.HDR.HDR10Plus,
+		}
+		isMain := ok && track.ID == mainTrack.ID
+		switch {
+		case dropExtra && !isMain:
+			if track.HDR.dynamic() {
+				ret = append(ret, fmt.Sprintf("%d: dropping extra video track loses %s metadata", track.ID, dynamic))
+			}
+		case rule.matches(track) && rule.codec == "h264":
+			// H.264 output is 8-bit, without mastering display metadata.
+			ret = append(ret, fmt.Sprintf("%d: re-encoding to %s loses %s metadata", track.ID, strings.ToUpper(rule.codec), track.HDR))
+		case rule.matches(track):
+			if track.HDR.dynamic() {
+				ret = append(ret, fmt.Sprintf("%d: re-encoding to %s loses %s metadata", track.ID, strings.ToUpper(rule.codec), dynamic))
+			}
+		case track.HDR.DolbyVision && ffmpegVersion != "" && compareVersions(ffmpegVersion, dvMinFFmpeg) < 0:
+			lost := HDRInfo{DolbyVision: true, DVProfile: track.HDR.DVProfile, DVCompatID: track.HDR.DVCompatID}
+			ret = append(ret, fmt.Sprintf("%d: copying with ffmpeg %s loses %s metadata (need ffmpeg %s or newer)", track.ID, ffmpegVersion, lost, dvMinFFmpeg))
+		}
+	}
+	return ret
+}
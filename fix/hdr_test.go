@@ -0,0 +1,155 @@
+package fix
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestParseHDRInfo(t *testing.T) {
+	data := []byte(`{
+		"frames": [
+			{
+				"stream_index": 0,
+				"side_data_list": [
+					{"side_data_type": "HDR Dynamic Metadata SMPTE2094-40 (HDR10+)"},
+					{"side_data_type": "Dolby Vision RPU Data"}
+				]
+			}
+		],
+		"streams": [
+			{
+				"index": 0,
+				"codec_name": "hevc",
+				"color_transfer": "smpte2084",
+				"side_data_list": [
+					{
+						"side_data_type": "DOVI configuration record",
+						"dv_version_major": 1,
+						"dv_profile": 8,
+						"dv_level": 6,
+						"rpu_present_flag": 1,
+						"el_present_flag": 0,
+						"bl_present_flag": 1,
+						"dv_bl_signal_compatibility_id": 1
+					}
+				]
+			},
+			{"index": 1, "codec_name": "hevc", "color_transfer": "arib-std-b67"},
+			{"index": 2, "codec_name": "h264", "color_transfer": "bt709"}
+		]
+	}`)
+
+	expected := map[int]HDRInfo{
+		0: {HDR10: true, HDR10Plus: true, DolbyVision: true, DVProfile: 8, DVCompatID: 1},
+		1: {HLG: true},
+		2: {},
+	}
+
+	result, err := parseHDRInfo(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected:\n%+v\ngot:\n%+v", expected, result)
+	}
+	if s := result[0].String(); s != "Dolby Vision profile 8.1, HDR10+, HDR10" {
+		t.Errorf("unexpected description: %q", s)
+	}
+}
+
+func TestHDRLosses(t *testing.T) {
+	tracks := []Track{
+		{ID: 0, Type: "video", CodecID: "HEVC/H.265/MPEG-H", Properties: TrackProperties{DefaultTrack: true}, HDR: HDRInfo{HDR10: true, DolbyVision: true, DVProfile: 7, DVCompatID: 6}},
+		{ID: 1, Type: "video", CodecID: "HEVC/H.265/MPEG-H", HDR: HDRInfo{DolbyVision: true, DVProfile: 7, DVCompatID: 6}},
+		{ID: 2, Type: "video", CodecID: "AVC/H.264/MPEG-4p10"},
+		{ID: 3, Type: "video", CodecID: "HEVC/H.265/MPEG-H", HDR: HDRInfo{HLG: true}},
+	}
+
+	testCases := []struct {
+		name      string
+		dropExtra bool
+		sources   []string
+		codec     string
+		ffmpeg    string
+		expected  []string
+	}{
+		{
+			name:   "Copy everything",
+			ffmpeg: "7.1",
+		},
+		{
+			name:   "Copy with unknown ffmpeg version",
+			ffmpeg: "",
+		},
+		{
+			name:   "Copy with old ffmpeg",
+			ffmpeg: "5.1.2",
+			expected: []string{
+				"0: copying with ffmpeg 5.1.2 loses Dolby Vision profile 7.6 metadata (need ffmpeg 6.0 or newer)",
+				"1: copying with ffmpeg 5.1.2 loses Dolby Vision profile 7.6 metadata (need ffmpeg 6.0 or newer)",
+			},
+		},
+		{
+			name:      "Drop extra video tracks",
+			dropExtra: true,
+			ffmpeg:    "7.1",
+			expected:  []string{"1: dropping extra video track loses Dolby Vision profile 7.6 metadata"},
+		},
+		{
+			name:    "Re-encode HEVC to H.264",
+			sources: []string{"hevc"},
+			codec:   "h264",
+			ffmpeg:  "7.1",
+			expected: []string{
+				"0: re-encoding to H264 loses Dolby Vision profile 7.6, HDR10 metadata",
+				"1: re-encoding to H264 loses Dolby Vision profile 7.6 metadata",
+				"3: re-encoding to H264 loses HLG metadata",
+			},
+		},
+		{
+			name:    "Re-encode HEVC to HEVC",
+			sources: []string{"hevc"},
+			codec:   "hevc",
+			ffmpeg:  "7.1",
+			expected: []string{
+				"0: re-encoding to HEVC loses Dolby Vision profile 7.6 metadata",
+				"1: re-encoding to HEVC loses Dolby Vision profile 7.6 metadata",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			codec := tc.codec
+			if codec == "" {
+				codec = "h264"
+			}
+			rule, err := newVideoRule(tc.sources, codec, 22, "medium")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			result := hdrLosses(tracks, tc.dropExtra, rule, tc.ffmpeg)
+			if !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("expected:\n%v\ngot:\n%v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestNewPlanFFmpegVersion(t *testing.T) {
+	tracks := []Track{
+		{ID: 0, Type: "video", CodecID: "HEVC/H.265/MPEG-H", File: "movie.mkv", HDR: HDRInfo{HDR10: true, DolbyVision: true, DVProfile: 8, DVCompatID: 1}},
+	}
+	for _, version := range []string{"", "7.1", "5.1"} {
+		opts := DefaultOptions()
+		opts.FFmpegVersion = version
+		plan, err := NewPlan(context.Background(), tracks, opts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if expected := version == "5.1"; (len(plan.Warnings) > 0) != expected {
+			t.Errorf("ffmpeg %q: expected warnings %v, got %v", version, expected, plan.Warnings)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// Hooks.
+//
+// Hooks are shell commands run before and after processing a file, for
+// example to notify a media server. They receive the details of the file in
+// environment variables and are killed when they take longer than the
+// HookTimeout option.
+
+package fix
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// Values for HookEnv.Hook.
+const (
+	PreHook  = "pre"
+	PostHook = "post"
+)
+
+// HookEnv holds the values passed to a hook.
+type HookEnv struct {
+	Hook   string // PreHook or PostHook.
+	Input  string // Input file.
+	Output string // Output file, if known.
+	Status string // Status of the file.
+	Error  string // Error message, if the file failed.
+	Report string // JSON report file.
+}
+
+// Environ returns the hook variables in the form "KEY=value".
+func (e HookEnv) Environ() []string {
+	return []string{
+		"VIDEOFIX_HOOK=" + e.Hook,
+		"VIDEOFIX_INPUT=" + e.Input,
+		"VIDEOFIX_OUTPUT=" + e.Output,
+		"VIDEOFIX_STATUS=" + e.Status,
+		"VIDEOFIX_ERROR=" + e.Error,
+		"VIDEOFIX_REPORT=" + e.Report,
+	}
+}
+
+// RunHook runs a hook command with the shell, adding the hook variables to
+// the environment. The output of the command goes to the standard error.
+// Non-zero exits return an error wrapping *exec.ExitError, and commands
+// running longer than timeout are killed and return an error wrapping
+// ErrTimeout.
+func RunHook(ctx context.Context, command string, timeout time.Duration, env HookEnv) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	shell := []string{"/bin/sh", "-c"}
+	if runtime.GOOS == "windows" {
+		shell = []string{"cmd", "/C"}
+	}
+	cmd := exec.CommandContext(ctx, shell[0], append(shell[1:], command)...)
+	cmd.Env = append(os.Environ(), env.Environ()...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	killProcessGroup(cmd)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s-hook failed: %w", env.Hook, commandError(ctx, err))
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package fix
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunHook(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "env.txt")
+	env := HookEnv{Hook: PostHook, Input: "in.mkv", Output: "out.mkv", Status: "fixed", Report: "report.json"}
+
+	testCases := []struct {
+		name       string
+		command    string
+		timeout    time.Duration
+		expectExit bool
+		expectErr  error
+	}{
+		{name: "Success", command: `echo "$VIDEOFIX_HOOK $VIDEOFIX_INPUT $VIDEOFIX_OUTPUT $VIDEOFIX_STATUS $VIDEOFIX_REPORT" > ` + out, timeout: time.Minute},
+		{name: "Non-zero exit", command: "exit 3", timeout: time.Minute, expectExit: true},
+		{name: "Timeout", command: "sleep 10", timeout: 100 * time.Millisecond, expectErr: ErrTimeout},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := RunHook(context.Background(), tc.command, tc.timeout, env)
+			var exitErr *exec.ExitError
+			switch {
+			case tc.expectExit:
+				if !errors.As(err, &exitErr) || exitErr.ExitCode() != 3 {
+					t.Errorf("expected exit status 3, got %v", err)
+				}
+			case tc.expectErr != nil:
+				if !errors.Is(err, tc.expectErr) {
+					t.Errorf("expected %v, got %v", tc.expectErr, err)
+				}
+			case err != nil:
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, expected := strings.TrimSpace(string(data)), "post in.mkv out.mkv fixed report.json"; result != expected {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+}
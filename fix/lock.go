@@ -0,0 +1,246 @@
+// Temporary file locks.
+//
+// While a plan runs, a lock file next to the temporary output file records
+// the PID and host of the owning process and when it started. A temporary
+// file is stale when its owner is gone: the lock belongs to a process on
+// this host that no longer exists (or whose PID now belongs to a process
+// started after the lock, or the system rebooted since), or there is no
+// lock and the file has not been modified for a while (left by a crash
+// before the lock was written, or by an older version of videofix).
+// Temporary files owned by a live process, or by a process on another host,
+// are never removed.
+
+package fix
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// tempSuffix is the suffix of temporary output files.
+	tempSuffix = ".TMP"
+	// lockSuffix is appended to the name of the temporary file to get
+	// the name of its lock file.
+	lockSuffix = ".lock"
+	// chaptersSuffix is appended to the name of the temporary file to get
+	// the name of the generated chapters file.
+	chaptersSuffix = ".chapters"
+	// unlockedStaleAge is the time without changes after which a
+	// temporary file without a lock is considered stale.
+	unlockedStaleAge = 10 * time.Minute
+	// startTimeSlack is the error allowed when comparing process start
+	// and boot times (only known to the second) with lock times.
+	startTimeSlack = 2 * time.Second
+)
+
+// ErrLocked is returned when the temporary file of a plan belongs to
+// another (possibly running) instance of videofix.
+var ErrLocked = errors.New("temporary file in use")
+
+// Lock holds the contents of a lock file.
+type Lock struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Started time.Time `json:"started"`
+	Input   string    `json:"input"`
+}
+
+// String returns a description of the owner of the lock.
+func (l Lock) String() string {
+	return fmt.Sprintf("PID %d on %s, started %s", l.PID, l.Host, l.Started.Local().Format(time.DateTime))
+}
+
+// TempInfo describes a temporary output file (or a lock without one).
+type TempInfo struct {
+	Path  string // Temporary file.
+	Lock  *Lock  // Contents of the lock file, or nil if there is none.
+	Stale bool   // True if the owner is gone and the file can be removed.
+	// Reason explains why the file is (or isn't) stale.
+	Reason string
+}
+
+// lockFile returns the name of the lock file for a temporary file.
+func lockFile(tempFile string) string {
+	return tempFile + lockSuffix
+}
+
+// readLock reads the lock file of a temporary file. It returns nil and no
+// error if there is no lock file.
+func readLock(tempFile string) (*Lock, error) {
+	data, err := os.ReadFile(lockFile(tempFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var lock Lock
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("error parsing lock file %s: %w", lockFile(tempFile), err)
+	}
+	return &lock, nil
+}
+
+// InspectTemp returns information about a temporary file and its lock.
+// It returns an error wrapping fs.ErrNotExist if neither exists.
+func InspectTemp(tempFile string) (TempInfo, error) {
+	info := TempInfo{Path: tempFile}
+	lock, err := readLock(tempFile)
+	if err != nil {
+		return info, err
+	}
+	info.Lock = lock
+
+	if lock != nil {
+		host, _ := os.Hostname()
+		switch {
+		case lock.Host != host:
+			info.Reason = "owned by " + lock.String()
+		case rebootedSince(lock.Started):
+			info.Stale = true
+			info.Reason = "system rebooted since " + lock.String()
+		case !processAlive(lock.PID):
+			info.Stale = true
+			info.Reason = "owner is gone: " + lock.String()
+		case pidReused(lock):
+			info.Stale = true
+			info.Reason = "owner is gone, PID reused: " + lock.String()
+		default:
+			info.Reason = "in use by " + lock.String()
+		}
+		return info, nil
+	}
+
+	st, err := os.Stat(tempFile)
+	if err != nil {
+		return info, err
+	}
+	age := time.Since(st.ModTime()).Round(time.Second)
+	if age < unlockedStaleAge {
+		info.Reason = fmt.Sprintf("no lock, modified %v ago", age)
+		return info, nil
+	}
+	info.Stale = true
+	info.Reason = fmt.Sprintf("no lock, not modified for %v", age)
+	return info, nil
+}
+
+// rebootedSince returns true if the system booted after t. Locks without a
+// start time, or systems where the boot time is unknown, return false.
+func rebootedSince(t time.Time) bool {
+	if t.IsZero() {
+		return false
+	}
+	boot, ok := bootTime()
+	return ok && boot.After(t)
+}
+
+// pidReused returns true if the process with the PID of the lock started
+// after the lock was created, and so can't be its owner.
+func pidReused(lock *Lock) bool {
+	if lock.Started.IsZero() {
+		return false
+	}
+	started, ok := processStarted(lock.PID)
+	return ok && started.After(lock.Started.Add(startTimeSlack))
+}
+
+// Remove removes a stale temporary file, its lock and its chapters file.
+func (t TempInfo) Remove() error {
+	if !t.Stale {
+		return fmt.Errorf("%w: %s (%s)", ErrLocked, t.Path, t.Reason)
+	}
+	for _, file := range []string{t.Path, t.Path + chaptersSuffix, lockFile(t.Path)} {
+		if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// isTempName returns true if path is named like the temporary output files
+// created by videofix (see NewPlan).
+func isTempName(path string) bool {
+	name, ok := strings.CutSuffix(filepath.Base(path), tempSuffix)
+	if !ok {
+		return false
+	}
+	ext := filepath.Ext(name)
+	return (ext == ".mkv" || ext == ".mp4") && strings.HasSuffix(strings.TrimSuffix(name, ext), outputSuffix)
+}
+
+// FindTemps returns all temporary files created by videofix (and locks
+// without a temporary file) in the directory tree under dir. Other files
+// ending in .TMP are ignored.
+func FindTemps(dir string) ([]TempInfo, error) {
+	seen := map[string]bool{}
+	var ret []TempInfo
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		tempFile := strings.TrimSuffix(path, lockSuffix)
+		if d.IsDir() || !isTempName(tempFile) || seen[tempFile] {
+			return nil
+		}
+		seen[tempFile] = true
+		info, err := InspectTemp(tempFile)
+		if err != nil {
+			return err
+		}
+		ret = append(ret, info)
+		return nil
+	})
+	return ret, err
+}
+
+// lockTemp creates the lock file for the temporary file of the plan. An
+// existing temporary file is removed if stale and the CleanStale option is
+// set, and refused otherwise.
+func lockTemp(plan Plan) error {
+	info, err := InspectTemp(plan.TempFile)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return err
+	case !info.Stale:
+		return fmt.Errorf("%w: %s (%s)", ErrLocked, plan.TempFile, info.Reason)
+	case !plan.Options.CleanStale:
+		return fmt.Errorf("stale temporary file %s (%s), remove it with \"videofix cleanup\" or --clean-stale", plan.TempFile, info.Reason)
+	default:
+		if err := info.Remove(); err != nil {
+			return fmt.Errorf("unable to remove stale temporary file: %w", err)
+		}
+	}
+
+	host, _ := os.Hostname()
+	data, err := json.Marshal(Lock{PID: os.Getpid(), Host: host, Started: time.Now(), Input: plan.Input})
+	if err != nil {
+		return err
+	}
+	// O_EXCL makes sure only one instance gets the lock.
+	f, err := os.OpenFile(lockFile(plan.TempFile), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("%w: %s (locked by another instance)", ErrLocked, plan.TempFile)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to create lock file: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("unable to write lock file: %w", err)
+	}
+	return f.Close()
+}
+
+// unlockTemp removes the lock file of the plan.
+func unlockTemp(plan Plan) {
+	_ = os.Remove(lockFile(plan.TempFile))
+}
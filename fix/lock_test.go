@@ -0,0 +1,155 @@
+package fix
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// deadPID returns the PID of a process that has exited.
+func deadPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("unable to run true: %v", err)
+	}
+	return cmd.Process.Pid
+}
+
+// writeLock writes a temporary file and its lock file.
+func writeLock(t *testing.T, tempFile string, lock Lock) {
+	t.Helper()
+	data, err := json.Marshal(lock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(lockFile(tempFile), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(tempFile, []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInspectTemp(t *testing.T) {
+	host, _ := os.Hostname()
+	// Reused PIDs are only detected where process start times are known.
+	_, startKnown := processStarted(os.Getpid())
+	testCases := []struct {
+		name     string
+		lock     *Lock
+		age      time.Duration
+		expected bool
+	}{
+		{name: "Live owner", lock: &Lock{PID: os.Getpid(), Host: host}},
+		{name: "Live owner, started", lock: &Lock{PID: os.Getpid(), Host: host, Started: time.Now()}},
+		{name: "Reused PID", lock: &Lock{PID: os.Getpid(), Host: host, Started: time.Unix(0, 0)}, expected: startKnown},
+		{name: "Dead owner", lock: &Lock{PID: -1, Host: host}, expected: true},
+		{name: "Other host", lock: &Lock{PID: -1, Host: host + ".example"}},
+		{name: "No lock, recent", age: time.Minute},
+		{name: "No lock, old", age: time.Hour, expected: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tempFile := filepath.Join(t.TempDir(), "movie_with_aac.mkv.TMP")
+			if tc.lock != nil {
+				writeLock(t, tempFile, *tc.lock)
+			} else {
+				if err := os.WriteFile(tempFile, []byte("partial"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				mtime := time.Now().Add(-tc.age)
+				if err := os.Chtimes(tempFile, mtime, mtime); err != nil {
+					t.Fatal(err)
+				}
+			}
+			info, err := InspectTemp(tempFile)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if info.Stale != tc.expected {
+				t.Errorf("expected stale %v, got %v (%s)", tc.expected, info.Stale, info.Reason)
+			}
+		})
+	}
+}
+
+func TestLockTemp(t *testing.T) {
+	host, _ := os.Hostname()
+	dir := t.TempDir()
+	plan := Plan{Input: filepath.Join(dir, "movie.mkv"), TempFile: filepath.Join(dir, "movie_with_aac.mkv.TMP")}
+
+	// Temp file owned by a live process.
+	writeLock(t, plan.TempFile, Lock{PID: os.Getpid(), Host: host})
+	if err := lockTemp(plan); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+
+	// Stale temp file, only removed with CleanStale.
+	writeLock(t, plan.TempFile, Lock{PID: deadPID(t), Host: host})
+	if err := lockTemp(plan); err == nil || errors.Is(err, ErrLocked) {
+		t.Fatalf("expected stale temp file error, got %v", err)
+	}
+	plan.Options.CleanStale = true
+	if err := lockTemp(plan); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(plan.TempFile); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected stale temp file to be removed, got %v", err)
+	}
+	lock, err := readLock(plan.TempFile)
+	if err != nil || lock == nil || lock.PID != os.Getpid() || lock.Input != plan.Input {
+		t.Fatalf("expected lock for this process, got %+v (%v)", lock, err)
+	}
+
+	// A second instance can't take the lock.
+	if err := lockTemp(plan); !errors.Is(err, ErrLocked) {
+		t.Errorf("expected ErrLocked, got %v", err)
+	}
+	unlockTemp(plan)
+	if lock, err := readLock(plan.TempFile); lock != nil || err != nil {
+		t.Errorf("expected no lock, got %+v (%v)", lock, err)
+	}
+}
+
+func TestFindTemps(t *testing.T) {
+	host, _ := os.Hostname()
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	live := filepath.Join(dir, "a_with_aac.mkv.TMP")
+	stale := filepath.Join(dir, "sub", "b_with_aac.mkv.TMP")
+	writeLock(t, live, Lock{PID: os.Getpid(), Host: host})
+	writeLock(t, stale, Lock{PID: deadPID(t), Host: host})
+	// Files not created by videofix are left alone.
+	for _, name := range []string{"movie.mkv", "Setup.TMP", "movie.mkv.TMP", "notes_with_aac.txt.TMP"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	temps, err := FindTemps(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(temps) != 2 || temps[0].Path != live || temps[0].Stale || temps[1].Path != stale || !temps[1].Stale {
+		t.Fatalf("unexpected temp files: %+v", temps)
+	}
+	if err := temps[0].Remove(); !errors.Is(err, ErrLocked) {
+		t.Errorf("expected ErrLocked, got %v", err)
+	}
+	if err := temps[1].Remove(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, file := range []string{stale, lockFile(stale)} {
+		if _, err := os.Stat(file); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected %s to be removed, got %v", file, err)
+		}
+	}
+}
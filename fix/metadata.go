@@ -0,0 +1,230 @@
+// Global metadata and chapter editing.
+//
+// By default, all global metadata and chapters are copied from the input
+// file. Optionally, junk global tags can be removed, the segment title set
+// from the filename, and chapters dropped or generated at fixed intervals
+// when the input file has none.
+
+package fix
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Values for Options.Chapters.
+const (
+	ChaptersKeep = "keep"
+	ChaptersDrop = "drop"
+	ChaptersAuto = "auto"
+)
+
+// junkTags lists the global tags removed by --strip-tags (lowercase).
+var junkTags = []string{"title", "encoder", "comment", "description", "synopsis", "purl"}
+
+var (
+	// titleStopRe matches the first word in a release filename that is not
+	// part of the title (resolution, source, codec, etc.)
+	titleStopRe = regexp.MustCompile(`(?i)^(\d{3,4}[pi]|4k|uhd|hdr\d*|dv|bluray|blu-ray|bdrip|brrip|remux|web|web-?dl|webrip|hdtv|dvdrip|x26[45]|h26[45]|hevc|avc|xvid|aac|ac3|dts|ddp?\d.*|proper|repack|extended|unrated)$`)
+	// yearRe matches a year in a filename.
+	yearRe = regexp.MustCompile(`^[(\[]?((19|20)\d\d)[)\]]?$`)
+)
+
+// containerInfo holds the global information about a media file.
+type containerInfo struct {
+	Duration time.Duration
+	Chapters int
+	Tags     map[string]string
+}
+
+// TagChange describes a change in a global tag. A blank New value removes
+// the tag.
+type TagChange struct {
+	Key string `json:"key"`
+	Old string `json:"old,omitempty"`
+	New string `json:"new,omitempty"`
+}
+
+// MetadataEdit holds the changes to the global metadata and chapters.
+type MetadataEdit struct {
+	Tags         []TagChange `json:"tags,omitempty"`
+	DropChapters bool        `json:"drop_chapters,omitempty"`
+	// Chapters generated when the input has none.
+	GenChapters     int           `json:"gen_chapters,omitempty"`
+	ChapterInterval time.Duration `json:"chapter_interval,omitempty"`
+}
+
+// ffprobeFormat holds the top-level JSON structure from ffprobe when called
+// with -show_format and -show_chapters.
+type ffprobeFormat struct {
+	Format struct {
+		Duration string            `json:"duration"`
+		Tags     map[string]string `json:"tags"`
+	} `json:"format"`
+	Chapters []json.RawMessage `json:"chapters"`
+}
+
+// parseContainerInfo parses the JSON output of ffprobe.
+func parseContainerInfo(data []byte) (containerInfo, error) {
+	var probe ffprobeFormat
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return containerInfo{}, fmt.Errorf("error parsing ffprobe JSON output: %w", err)
+	}
+	info := containerInfo{
+		Chapters: len(probe.Chapters),
+		Tags:     probe.Format.Tags,
+	}
+	if secs, err := strconv.ParseFloat(probe.Format.Duration, 64); err == nil {
+		info.Duration = time.Duration(secs * float64(time.Second))
+	}
+	return info, nil
+}
+
+// readContainerInfo returns the duration, number of chapters and global
+// tags of the input file using ffprobe.
+func readContainerInfo(ctx context.Context, inputFile string, ffprobe string) (containerInfo, error) {
+	output, err := probeOutput(ctx, ffprobe,
+		"-v", "error",
+		"-show_format",
+		"-show_chapters",
+		"-of", "json",
+		inputFile)
+	if err != nil {
+		return containerInfo{}, err
+	}
+	return parseContainerInfo(output)
+}
+
+// titleFromFilename returns a clean title from a release style filename
+// (without extension). E.g: "Movie.Name.2020.1080p.BluRay-GRP" returns
+// "Movie Name (2020)".
+func titleFromFilename(name string) string {
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return r == '.' || r == '_' || r == ' '
+	})
+
+	var title []string
+	for i, word := range words {
+		if m := yearRe.FindStringSubmatch(word); m != nil && i > 0 {
+			title = append(title, "("+m[1]+")")
+			break
+		}
+		if titleStopRe.MatchString(word) && i > 0 {
+			break
+		}
+		title = append(title, word)
+	}
+	return strings.Join(title, " ")
+}
+
+// planMetadata returns the changes to the global metadata and chapters of a
+// file, given its container information and the options in use.
+func planMetadata(info containerInfo, filename string, stripTags bool, setTitle bool, chapters string, interval time.Duration) MetadataEdit {
+	var edit MetadataEdit
+
+	// Sort keys to produce a stable list of changes.
+	keys := make([]string, 0, len(info.Tags))
+	for key := range info.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	newTitle := ""
+	if setTitle {
+		newTitle = titleFromFilename(filename)
+	}
+	titleDone := false
+
+	for _, key := range keys {
+		lkey := strings.ToLower(key)
+		if lkey == "title" && setTitle {
+			titleDone = true
+			if info.Tags[key] != newTitle {
+				edit.Tags = append(edit.Tags, TagChange{Key: key, Old: info.Tags[key], New: newTitle})
+			}
+			continue
+		}
+		if stripTags {
+			for _, junk := range junkTags {
+				if lkey == junk {
+					edit.Tags = append(edit.Tags, TagChange{Key: key, Old: info.Tags[key]})
+				}
+			}
+		}
+	}
+	if setTitle && !titleDone && newTitle != "" {
+		edit.Tags = append(edit.Tags, TagChange{Key: "title", New: newTitle})
+	}
+
+	switch chapters {
+	case ChaptersDrop:
+		edit.DropChapters = info.Chapters > 0
+	case ChaptersAuto:
+		if info.Chapters == 0 && interval > 0 && info.Duration > 0 {
+			edit.GenChapters = int((info.Duration + interval - 1) / interval)
+			edit.ChapterInterval = interval
+		}
+	}
+	return edit
+}
+
+// chapterMetadata returns an ffmetadata file with chapters starting every
+// interval until the end of the file.
+func chapterMetadata(duration time.Duration, interval time.Duration) string {
+	var sb strings.Builder
+	sb.WriteString(";FFMETADATA1\n")
+	for n, start := 1, time.Duration(0); start < duration; n, start = n+1, start+interval {
+		end := min(start+interval, duration)
+		fmt.Fprintf(&sb, "[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=Chapter %02d\n", start.Milliseconds(), end.Milliseconds(), n)
+	}
+	return sb.String()
+}
+
+// Diff returns a human readable list of changes.
+func (m MetadataEdit) Diff() []string {
+	var ret []string
+	for _, tag := range m.Tags {
+		switch {
+		case tag.Old == "":
+			ret = append(ret, fmt.Sprintf("+ %s: %q", tag.Key, tag.New))
+		case tag.New == "":
+			ret = append(ret, fmt.Sprintf("- %s: %q", tag.Key, tag.Old))
+		default:
+			ret = append(ret, fmt.Sprintf("~ %s: %q --> %q", tag.Key, tag.Old, tag.New))
+		}
+	}
+	if m.DropChapters {
+		ret = append(ret, "- chapters: all chapters removed")
+	}
+	if m.GenChapters > 0 {
+		ret = append(ret, fmt.Sprintf("+ chapters: %d chapters generated every %s", m.GenChapters, m.ChapterInterval))
+	}
+	return ret
+}
+
+// metadataArgs returns the ffmpeg arguments to copy the global metadata and
+// chapters from the main input, applying the changes. chaptersInput is the
+// ffmpeg input number of the generated chapters file, if any.
+func metadataArgs(m MetadataEdit, chaptersInput int) []string {
+	chapters := "0" // Copy all chapters.
+	switch {
+	case m.DropChapters:
+		chapters = "-1"
+	case m.GenChapters > 0:
+		chapters = strconv.Itoa(chaptersInput)
+	}
+	args := []string{
+		"-map_chapters", chapters,
+		"-map_metadata", "0", // Copy all metadata
+	}
+	for _, tag := range m.Tags {
+		args = append(args, "-metadata", tag.Key+"="+tag.New)
+	}
+	return args
+}
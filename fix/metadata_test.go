@@ -0,0 +1,169 @@
+package fix
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTitleFromFilename(t *testing.T) {
+	testCases := []struct {
+		filename string
+		expected string
+	}{
+		{"Movie.Name.2020.1080p.BluRay.x264-GRP", "Movie Name (2020)"},
+		{"2001.A.Space.Odyssey.1968.2160p.UHD", "2001 A Space Odyssey (1968)"},
+		{"Some_Show_S01E02_720p_WEB-DL", "Some Show S01E02"},
+		{"Movie Name (1999)", "Movie Name (1999)"},
+		{"Plain title", "Plain title"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.filename, func(t *testing.T) {
+			if result := titleFromFilename(tc.filename); result != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestParseContainerInfo(t *testing.T) {
+	data := []byte(`{
+		"chapters": [{"id": 1}, {"id": 2}],
+		"format": {"duration": "5400.500000", "tags": {"title": "Movie-GRP", "ENCODER": "libebml"}}
+	}`)
+	expected := containerInfo{
+		Duration: 5400*time.Second + 500*time.Millisecond,
+		Chapters: 2,
+		Tags:     map[string]string{"title": "Movie-GRP", "ENCODER": "libebml"},
+	}
+
+	result, err := parseContainerInfo(data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected:\n%+v\ngot:\n%+v", expected, result)
+	}
+}
+
+func TestPlanMetadata(t *testing.T) {
+	info := containerInfo{
+		Duration: 25 * time.Minute,
+		Tags:     map[string]string{"title": "www.example.com - Movie", "ENCODER": "libebml", "DATE_RELEASED": "2020"},
+	}
+
+	testCases := []struct {
+		name      string
+		info      containerInfo
+		stripTags bool
+		setTitle  bool
+		chapters  string
+		expected  MetadataEdit
+	}{
+		{
+			name:     "No changes",
+			info:     info,
+			chapters: "keep",
+		},
+		{
+			name:      "Strip tags",
+			info:      info,
+			stripTags: true,
+			chapters:  "keep",
+			expected: MetadataEdit{Tags: []TagChange{
+				{Key: "ENCODER", Old: "libebml"},
+				{Key: "title", Old: "www.example.com - Movie"},
+			}},
+		},
+		{
+			name:      "Strip tags and set title",
+			info:      info,
+			stripTags: true,
+			setTitle:  true,
+			chapters:  "keep",
+			expected: MetadataEdit{Tags: []TagChange{
+				{Key: "ENCODER", Old: "libebml"},
+				{Key: "title", Old: "www.example.com - Movie", New: "Movie Name (2020)"},
+			}},
+		},
+		{
+			name:     "Set title on file without one",
+			info:     containerInfo{},
+			setTitle: true,
+			chapters: "keep",
+			expected: MetadataEdit{Tags: []TagChange{{Key: "title", New: "Movie Name (2020)"}}},
+		},
+		{
+			name:     "Generate chapters",
+			info:     info,
+			chapters: "auto",
+			expected: MetadataEdit{GenChapters: 3, ChapterInterval: 10 * time.Minute},
+		},
+		{
+			name:     "Do not generate chapters when they exist",
+			info:     containerInfo{Duration: time.Hour, Chapters: 4},
+			chapters: "auto",
+		},
+		{
+			name:     "Drop chapters",
+			info:     containerInfo{Duration: time.Hour, Chapters: 4},
+			chapters: "drop",
+			expected: MetadataEdit{DropChapters: true},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := planMetadata(tc.info, "Movie.Name.2020.1080p-GRP", tc.stripTags, tc.setTitle, tc.chapters, 10*time.Minute)
+			if !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("expected:\n%+v\ngot:\n%+v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestChapterMetadata(t *testing.T) {
+	expected := ";FFMETADATA1\n" +
+		"[CHAPTER]\nTIMEBASE=1/1000\nSTART=0\nEND=600000\ntitle=Chapter 01\n" +
+		"[CHAPTER]\nTIMEBASE=1/1000\nSTART=600000\nEND=900000\ntitle=Chapter 02\n"
+
+	if result := chapterMetadata(15*time.Minute, 10*time.Minute); result != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, result)
+	}
+}
+
+func TestMetadataArgs(t *testing.T) {
+	testCases := []struct {
+		name     string
+		edit     MetadataEdit
+		expected []string
+	}{
+		{
+			name:     "Copy everything",
+			expected: []string{"-map_chapters", "0", "-map_metadata", "0"},
+		},
+		{
+			name: "Drop chapters and change tags",
+			edit: MetadataEdit{
+				DropChapters: true,
+				Tags:         []TagChange{{Key: "ENCODER", Old: "x"}, {Key: "title", New: "Movie"}},
+			},
+			expected: []string{"-map_chapters", "-1", "-map_metadata", "0", "-metadata", "ENCODER=", "-metadata", "title=Movie"},
+		},
+		{
+			name:     "Generated chapters",
+			edit:     MetadataEdit{GenChapters: 3, ChapterInterval: 10 * time.Minute},
+			expected: []string{"-map_chapters", "2", "-map_metadata", "0"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := metadataArgs(tc.edit, 2)
+			if !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("expected:\n%v\ngot:\n%v", tc.expected, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+// Processing options.
+
+package fix
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Options holds the settings used to process a file. Options are passed
+// explicitly to all functions, so files with different settings can be
+// processed concurrently.
+type Options struct {
+	// Audio and subtitles.
+	Lang         string   `json:"lang"`          // Default language for audio and subtitle tracks.
+	Prune        bool     `json:"prune"`         // Prune tracks not in the default language or "und".
+	Sidecars     bool     `json:"sidecars"`      // Import sidecar subtitle and audio files.
+	Transcode    []string `json:"transcode"`     // Audio codecs to convert to AAC.
+	AudioBitrate string   `json:"audio-bitrate"` // Bitrate of the AAC tracks.
+	AACEncoder   string   `json:"aac-encoder"`   // ffmpeg encoder used to produce AAC.
+
+	// Video.
+	Covers         string   `json:"covers"`           // What to do with cover art (CoversDrop or CoversAttach).
+	DropExtraVideo bool     `json:"drop-extra-video"` // Drop all video tracks except the main one.
+	VideoReencode  []string `json:"video-reencode"`   // Video codec classes to re-encode.
+	VideoCodec     string   `json:"video-codec"`      // Target codec for re-encoded video tracks.
+	VideoCRF       int      `json:"video-crf"`        // CRF for re-encoded video tracks.
+	VideoPreset    string   `json:"video-preset"`     // Encoder preset for re-encoded video tracks.
+	HDRPolicy      string   `json:"hdr-policy"`       // What to do when HDR metadata would be lost.
+
+	// Metadata and titles.
+	StripTags         bool          `json:"strip-tags"`          // Remove junk global tags.
+	TitleFromFilename bool          `json:"title-from-filename"` // Set the title from the filename.
+	Chapters          string        `json:"chapters"`            // What to do with chapters.
+	ChapterInterval   time.Duration `json:"chapter-interval"`    // Interval between generated chapters.
+	Titles            string        `json:"titles"`              // Which track titles to generate.
+	TitleTemplate     string        `json:"title-template"`      // Template for generated track titles.
+
+	// External programs.
+	ToolPaths
+	// FFmpegVersion is the version of ffmpeg (see Capabilities.Version),
+	// used to warn about Dolby Vision metadata dropped by old versions. It
+	// is not probed by NewPlan; leave it blank to skip the check.
+	FFmpegVersion string `json:"-"`
+
+	// Output.
+	OutputDir     string  `json:"output"`         // Output directory (blank to use the input directory).
+	DryRun        bool    `json:"dry-run"`        // Show what would be done but do not change any files.
+	Verify        string  `json:"verify"`         // How to verify the output before replacing the input.
+	SpaceMargin   int     `json:"space-margin"`   // Free space required above the estimated output size (percent).
+	SpacePolicy   string  `json:"space-policy"`   // What to do without enough free space.
+	CleanStale    bool    `json:"clean-stale"`    // Remove stale temporary files left by crashed runs.
+	TimeoutFactor float64 `json:"timeout-factor"` // ffmpeg timeout as a multiple of the duration (see Plan.Timeout).
+
+	// Hooks.
+	PreHook     string        `json:"pre-hook"`     // Command run before processing a file.
+	PostHook    string        `json:"post-hook"`    // Command run after processing a file.
+	HookTimeout time.Duration `json:"hook-timeout"` // Maximum time to run each hook.
+}
+
+// DefaultOptions returns the default processing options.
+func DefaultOptions() Options {
+	return Options{
+		Lang:            "eng",
+		Transcode:       []string{eac3Codec},
+		AudioBitrate:    aacBitrate,
+		AACEncoder:      "aac",
+		Covers:          CoversDrop,
+		VideoCodec:      "hevc",
+		VideoCRF:        22,
+		VideoPreset:     "medium",
+		HDRPolicy:       HDRPolicyWarn,
+		Chapters:        ChaptersKeep,
+		ChapterInterval: 10 * time.Minute,
+		Titles:          TitlesOff,
+		TitleTemplate:   DefaultTitleTemplate,
+		TimeoutFactor:   1,
+		Verify:          VerifyProbe,
+		SpaceMargin:     10,
+		SpacePolicy:     SpacePolicyRefuse,
+		HookTimeout:     time.Minute,
+	}
+}
+
+// listValue is a flag.Value holding a comma separated list of strings.
+type listValue []string
+
+func (l *listValue) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listValue) Set(s string) error {
+	*l = nil
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// BindFlags defines one flag for each option in the flag set, using the
+// current values as defaults and storing the parsed values in the options.
+func (o *Options) BindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.Lang, "lang", o.Lang, "Default language for audio and subtitle tracks")
+	fs.BoolVar(&o.Prune, "prune", o.Prune, "Prune tracks not in the default language or 'und'")
+	fs.StringVar(&o.OutputDir, "output", o.OutputDir, "Output directory.")
+	fs.BoolVar(&o.Sidecars, "sidecars", o.Sidecars, "Import subtitle and audio files with the same base name as the input")
+	fs.StringVar(&o.Covers, "covers", o.Covers, "What to do with cover art video tracks: 'drop' or 'attach' as MKV attachments")
+	fs.BoolVar(&o.DropExtraVideo, "drop-extra-video", o.DropExtraVideo, "Drop all video tracks except the main one (e.g. alternate angles)")
+	fs.StringVar(&o.VideoCodec, "video-codec", o.VideoCodec, "Target codec for re-encoded video tracks: 'hevc' or 'h264'")
+	fs.Var((*listValue)(&o.VideoReencode), "video-reencode", "Comma separated list of video codecs to re-encode (e.g. 'mpeg2,vc1,h264-10bit')")
+	fs.IntVar(&o.VideoCRF, "video-crf", o.VideoCRF, "CRF (quality) for re-encoded video tracks")
+	fs.StringVar(&o.VideoPreset, "video-preset", o.VideoPreset, "Encoder preset for re-encoded video tracks")
+	fs.BoolVar(&o.DryRun, "dry-run", o.DryRun, "Show what would be done, but do not change any files")
+	fs.StringVar(&o.Verify, "verify", o.Verify, "Verify the output before replacing the input: 'probe' (check tracks and duration), 'decode' (also decode the whole file) or 'off'")
+	fs.IntVar(&o.SpaceMargin, "space-margin", o.SpaceMargin, "Free space required above the estimated output size, in percent")
+	fs.StringVar(&o.SpacePolicy, "space-policy", o.SpacePolicy, "What to do without enough free space for the output: 'refuse' or 'wait'")
+	fs.BoolVar(&o.CleanStale, "clean-stale", o.CleanStale, "Remove stale temporary files left by crashed runs instead of skipping the file")
+	fs.Float64Var(&o.TimeoutFactor, "timeout-factor", o.TimeoutFactor, "Kill ffmpeg after this many times the duration of the file (10x more when re-encoding video, 0 to disable)")
+	fs.StringVar(&o.HDRPolicy, "hdr-policy", o.HDRPolicy, "What to do when HDR10+/Dolby Vision metadata would be lost: 'warn' or 'refuse'")
+	fs.BoolVar(&o.StripTags, "strip-tags", o.StripTags, "Remove junk global tags (title, encoder, comment, etc)")
+	fs.BoolVar(&o.TitleFromFilename, "title-from-filename", o.TitleFromFilename, "Set the title of the file from its filename")
+	fs.StringVar(&o.Chapters, "chapters", o.Chapters, "What to do with chapters: 'keep', 'drop' or 'auto' (generate when none exist)")
+	fs.DurationVar(&o.ChapterInterval, "chapter-interval", o.ChapterInterval, "Interval between automatically generated chapters")
+	fs.StringVar(&o.Titles, "titles", o.Titles, "Generate audio and subtitle track titles: 'off', 'all' tracks or only tracks with 'junk' titles")
+	fs.StringVar(&o.TitleTemplate, "title-template", o.TitleTemplate, "Template for generated track titles")
+	fs.Var((*listValue)(&o.Transcode), "transcode", "Comma separated list of audio codecs to convert to AAC")
+	fs.StringVar(&o.AudioBitrate, "audio-bitrate", o.AudioBitrate, "Bitrate of the AAC audio tracks")
+	fs.StringVar(&o.MKVMerge, "mkvmerge", o.MKVMerge, "Path to mkvmerge (default: $VIDEOFIX_MKVMERGE, or search the PATH)")
+	fs.StringVar(&o.FFmpeg, "ffmpeg", o.FFmpeg, "Path to ffmpeg (default: $VIDEOFIX_FFMPEG, or search the PATH)")
+	fs.StringVar(&o.FFprobe, "ffprobe", o.FFprobe, "Path to ffprobe (default: $VIDEOFIX_FFPROBE, or search the PATH)")
+	fs.StringVar(&o.AACEncoder, "aac-encoder", o.AACEncoder, "ffmpeg AAC encoder: 'aac' or 'libfdk_aac'")
+	fs.StringVar(&o.PreHook, "pre-hook", o.PreHook, "Shell command run before processing each file (a non-zero exit skips the file)")
+	fs.StringVar(&o.PostHook, "post-hook", o.PostHook, "Shell command run after processing each file")
+	fs.DurationVar(&o.HookTimeout, "hook-timeout", o.HookTimeout, "Kill hooks running for longer than this")
+}
+
+// Hash returns a short hash of the options affecting the contents of the
+// output file. Options like the tool paths, timeouts, verification and
+// hooks don't change the output and are ignored.
+func (o Options) Hash() string {
+	o.ToolPaths = ToolPaths{}
+	o.OutputDir = ""
+	o.DryRun = false
+	o.Verify = ""
+	o.SpaceMargin = 0
+	o.SpacePolicy = ""
+	o.CleanStale = false
+	o.TimeoutFactor = 0
+	o.PreHook, o.PostHook, o.HookTimeout = "", "", 0
+	data, _ := json.Marshal(o)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Validate returns an error if any of the options has an invalid value.
+func (o Options) Validate() error {
+	if o.Covers != CoversDrop && o.Covers != CoversAttach {
+		return fmt.Errorf("invalid covers value: %q (use %q or %q)", o.Covers, CoversDrop, CoversAttach)
+	}
+	if _, err := o.videoRule(); err != nil {
+		return err
+	}
+	if o.HDRPolicy != HDRPolicyWarn && o.HDRPolicy != HDRPolicyRefuse {
+		return fmt.Errorf("invalid hdr-policy value: %q (use %q or %q)", o.HDRPolicy, HDRPolicyWarn, HDRPolicyRefuse)
+	}
+	if o.Chapters != ChaptersKeep && o.Chapters != ChaptersDrop && o.Chapters != ChaptersAuto {
+		return fmt.Errorf("invalid chapters value: %q (use %q, %q or %q)", o.Chapters, ChaptersKeep, ChaptersDrop, ChaptersAuto)
+	}
+	if o.Titles != TitlesOff && o.Titles != TitlesAll && o.Titles != TitlesJunk {
+		return fmt.Errorf("invalid titles value: %q (use %q, %q or %q)", o.Titles, TitlesOff, TitlesAll, TitlesJunk)
+	}
+	if o.AACEncoder != "aac" && o.AACEncoder != "libfdk_aac" {
+		return fmt.Errorf("invalid aac-encoder value: %q (use 'aac' or 'libfdk_aac')", o.AACEncoder)
+	}
+	if o.Verify != VerifyOff && o.Verify != VerifyProbe && o.Verify != VerifyDecode {
+		return fmt.Errorf("invalid verify value: %q (use %q, %q or %q)", o.Verify, VerifyProbe, VerifyDecode, VerifyOff)
+	}
+	if o.SpaceMargin < 0 {
+		return fmt.Errorf("invalid space-margin value: %d (must not be negative)", o.SpaceMargin)
+	}
+	if o.SpacePolicy != SpacePolicyRefuse && o.SpacePolicy != SpacePolicyWait {
+		return fmt.Errorf("invalid space-policy value: %q (use %q or %q)", o.SpacePolicy, SpacePolicyRefuse, SpacePolicyWait)
+	}
+	if _, err := parseBitrate(o.AudioBitrate); err != nil {
+		return err
+	}
+	if o.TimeoutFactor < 0 {
+		return fmt.Errorf("invalid timeout-factor value: %v (must not be negative)", o.TimeoutFactor)
+	}
+	if o.HookTimeout <= 0 {
+		return fmt.Errorf("invalid hook-timeout value: %v (must be positive)", o.HookTimeout)
+	}
+	if o.Prune && o.Lang == "" {
+		return fmt.Errorf("when prune is specified, lang becomes mandatory")
+	}
+	return nil
+}
+
+// videoRule returns the video re-encoding rule for these options.
+func (o Options) videoRule() (videoRule, error) {
+	return newVideoRule(o.VideoReencode, o.VideoCodec, o.VideoCRF, o.VideoPreset)
+}
@@ -0,0 +1,89 @@
+package fix
+
+import (
+	"flag"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBindFlags(t *testing.T) {
+	opts := DefaultOptions()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	opts.BindFlags(fs)
+
+	args := []string{"--lang", "jpn", "--prune", "--transcode", "E-AC-3, DTS", "--chapter-interval", "5m"}
+	if err := fs.Parse(args); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := DefaultOptions()
+	expected.Lang = "jpn"
+	expected.Prune = true
+	expected.Transcode = []string{"E-AC-3", "DTS"}
+	expected.ChapterInterval = 5 * time.Minute
+
+	if !reflect.DeepEqual(opts, expected) {
+		t.Errorf("expected:\n%+v\ngot:\n%+v", expected, opts)
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	testCases := []struct {
+		name      string
+		modify    func(*Options)
+		expectErr bool
+	}{
+		{name: "Defaults", modify: func(o *Options) {}},
+		{name: "Invalid covers", modify: func(o *Options) { o.Covers = "keep" }, expectErr: true},
+		{name: "Invalid video codec", modify: func(o *Options) { o.VideoCodec = "vp9" }, expectErr: true},
+		{name: "Invalid HDR policy", modify: func(o *Options) { o.HDRPolicy = "ignore" }, expectErr: true},
+		{name: "Invalid chapters", modify: func(o *Options) { o.Chapters = "none" }, expectErr: true},
+		{name: "Invalid titles", modify: func(o *Options) { o.Titles = "some" }, expectErr: true},
+		{name: "Invalid AAC encoder", modify: func(o *Options) { o.AACEncoder = "libopus" }, expectErr: true},
+		{name: "Invalid verify", modify: func(o *Options) { o.Verify = "full" }, expectErr: true},
+		{name: "Invalid space policy", modify: func(o *Options) { o.SpacePolicy = "ignore" }, expectErr: true},
+		{name: "Negative space margin", modify: func(o *Options) { o.SpaceMargin = -1 }, expectErr: true},
+		{name: "Invalid audio bitrate", modify: func(o *Options) { o.AudioBitrate = "fast" }, expectErr: true},
+		{name: "Zero hook timeout", modify: func(o *Options) { o.HookTimeout = 0 }, expectErr: true},
+		{name: "Prune without language", modify: func(o *Options) { o.Prune, o.Lang = true, "" }, expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			tc.modify(&opts)
+			err := opts.Validate()
+			if tc.expectErr != (err != nil) {
+				t.Errorf("expected error=%v, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestOptionsHash(t *testing.T) {
+	testCases := []struct {
+		name     string
+		modify   func(*Options)
+		expected bool // True if the hash should stay the same.
+	}{
+		{name: "Defaults", modify: func(o *Options) {}, expected: true},
+		{name: "Dry run", modify: func(o *Options) { o.DryRun = true }, expected: true},
+		{name: "Tool path", modify: func(o *Options) { o.FFmpeg = "/opt/ffmpeg" }, expected: true},
+		{name: "Verify", modify: func(o *Options) { o.Verify = VerifyDecode }, expected: true},
+		{name: "Hooks", modify: func(o *Options) { o.PostHook = "notify.sh" }, expected: true},
+		{name: "Language", modify: func(o *Options) { o.Lang = "jpn" }},
+		{name: "Audio bitrate", modify: func(o *Options) { o.AudioBitrate = "192k" }},
+	}
+
+	base := DefaultOptions().Hash()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			tc.modify(&opts)
+			if result := opts.Hash() == base; result != tc.expected {
+				t.Errorf("expected same hash=%v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
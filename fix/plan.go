@@ -0,0 +1,378 @@
+// Planning and execution.
+//
+// NewPlan decides what to do with each track and records the decisions in a
+// Plan, without running anything. The ffmpeg command line is generated from
+// the Plan, so plans can be reviewed, serialized and replayed.
+
+package fix
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Track actions.
+const (
+	ActionCopy      = "copy"      // Copy the track as is.
+	ActionTranscode = "transcode" // Convert the audio track to AAC.
+	ActionReencode  = "reencode"  // Re-encode the video track.
+	ActionAttach    = "attach"    // Attach the cover art track.
+	ActionDrop      = "drop"      // Leave the track out of the output.
+)
+
+// streamTypes maps track types to ffmpeg stream specifiers.
+var streamTypes = map[string]string{
+	mkvVideoType: "v",
+	mkvAudioType: "a",
+	mkvSubType:   "s",
+}
+
+// TrackPlan describes what happens to an input track.
+type TrackPlan struct {
+	Track  Track  `json:"track"`
+	Action string `json:"action"`
+	Reason string `json:"reason,omitempty"`
+	// Codec is the output codec for transcoded and re-encoded tracks.
+	Codec       string `json:"codec,omitempty"`
+	Language    string `json:"language,omitempty"`
+	Disposition string `json:"disposition,omitempty"`
+	// Title is the new title of the track, or blank to keep the current one.
+	Title string `json:"title,omitempty"`
+}
+
+// Plan describes how a file will be fixed. Plans are created by NewPlan and
+// run by Execute.
+type Plan struct {
+	Input    string       `json:"input"`     // Main input file.
+	Output   string       `json:"output"`    // Final output file.
+	TempFile string       `json:"temp_file"` // Temporary file written by ffmpeg.
+	Options  Options      `json:"options"`   // Options used to create the plan.
+	Metadata MetadataEdit `json:"metadata"`  // Global metadata and chapter changes.
+	// Tracks lists the video, audio and subtitle tracks in output order,
+	// including the dropped tracks.
+	Tracks []TrackPlan `json:"tracks"`
+	// Chapters holds the ffmetadata file with the generated chapters, if any.
+	Chapters string `json:"chapters,omitempty"`
+	// Warnings holds the HDR metadata that would be lost.
+	Warnings []string `json:"warnings,omitempty"`
+	// Inputs holds the size and modification time of the input files when
+	// the plan was created.
+	Inputs map[string]FileStamp `json:"inputs,omitempty"`
+	// Duration is the duration of the main input file, or zero if unknown.
+	Duration time.Duration `json:"duration,omitempty"`
+}
+
+// chaptersFile returns the name of the generated chapters file.
+func (p Plan) chaptersFile() string {
+	return p.TempFile + chaptersSuffix
+}
+
+// NewPlan decides what to do with each track and returns the resulting
+// plan. Tracks must contain the tracks of the main input file as returned
+// by Probe, optionally followed by the tracks returned by ProbeSidecars.
+func NewPlan(ctx context.Context, tracks []Track, opts Options) (Plan, error) {
+	if err := opts.Validate(); err != nil {
+		return Plan{}, err
+	}
+
+	var infile string
+	var duration time.Duration
+	for _, track := range tracks {
+		if !track.Sidecar {
+			infile, duration = track.File, track.Duration
+			break
+		}
+	}
+	if infile == "" {
+		return Plan{}, fmt.Errorf("no tracks found in the main input file")
+	}
+
+	// Generate the output filename
+	dirname := filepath.Dir(infile)
+	filename := filepath.Base(infile)
+	extension := strings.ToLower(filepath.Ext(filename))
+	filenameNoExt := strings.TrimSuffix(filename, filepath.Ext(filename))
+
+	if extension != ".mkv" && extension != ".mp4" {
+		return Plan{}, fmt.Errorf("not an MKV or MP4 file: %s", infile)
+	}
+
+	// Use outputDir if specified
+	if opts.OutputDir != "" {
+		dirname = opts.OutputDir
+	}
+	plan := Plan{
+		Input:    infile,
+		Output:   filepath.Join(dirname, filenameNoExt+extension),
+		TempFile: filepath.Join(dirname, filenameNoExt+outputSuffix+extension+tempSuffix),
+		Options:  opts,
+		Inputs:   stampInputs(tracks),
+		Duration: duration,
+	}
+
+	// Warn (or refuse) when HDR metadata would be lost.
+	rule, err := opts.videoRule()
+	if err != nil {
+		return Plan{}, err
+	}
+	plan.Warnings = hdrLosses(tracks, opts.DropExtraVideo, rule, opts.FFmpegVersion)
+	if len(plan.Warnings) > 0 && opts.HDRPolicy == HDRPolicyRefuse {
+		return Plan{}, fmt.Errorf("refusing to lose HDR metadata (--hdr-policy=%s): %s", HDRPolicyRefuse, strings.Join(plan.Warnings, "; "))
+	}
+
+	// If pruning is enabled, check if any track type is completely removed.
+	if opts.Prune {
+		if err := pruneOK(tracks, opts.Lang); err != nil {
+			return Plan{}, err
+		}
+	}
+
+	// Global metadata and chapters.
+	if opts.StripTags || opts.TitleFromFilename || opts.Chapters != ChaptersKeep {
+		info, err := readContainerInfo(ctx, infile, opts.path("ffprobe"))
+		if err != nil {
+			return Plan{}, err
+		}
+		plan.Metadata = planMetadata(info, filenameNoExt, opts.StripTags, opts.TitleFromFilename, opts.Chapters, opts.ChapterInterval)
+		if plan.Metadata.GenChapters > 0 {
+			plan.Chapters = chapterMetadata(info.Duration, plan.Metadata.ChapterInterval)
+		}
+	}
+
+	// Video tracks go first, to maintain the V/A/S order in the output file.
+	plan.Tracks = append(plan.Tracks, planVideo(tracks, opts.Covers, opts.DropExtraVideo, rule)...)
+	plan.Tracks = append(plan.Tracks, planAudio(tracks, opts)...)
+	plan.Tracks = append(plan.Tracks, planSubtitles(tracks, opts)...)
+	return plan, nil
+}
+
+// Changes returns a human readable list of the changes the plan makes to
+// the input file, or nil if there is nothing to fix.
+func (p Plan) Changes() []string {
+	var ret []string
+	if strings.ToLower(filepath.Ext(p.Input)) == ".mp4" {
+		ret = append(ret, "convert MP4 to MKV")
+	}
+	ret = append(ret, p.Metadata.Diff()...)
+
+	for _, tp := range p.Tracks {
+		track := fmt.Sprintf("%s track %d", tp.Track.Type, tp.Track.ID)
+		switch {
+		case tp.Action == ActionDrop && tp.Track.Sidecar:
+			continue
+		case tp.Action == ActionDrop:
+			ret = append(ret, fmt.Sprintf("%s: drop (%s)", track, tp.Reason))
+			continue
+		case tp.Track.Sidecar:
+			ret = append(ret, fmt.Sprintf("%s: import from %s", track, tp.Track.File))
+		case tp.Action != ActionCopy:
+			ret = append(ret, fmt.Sprintf("%s: %s (%s)", track, tp.Action, tp.Reason))
+		}
+		if tp.Title != "" && tp.Title != tp.Track.Properties.TrackName {
+			ret = append(ret, fmt.Sprintf("%s: set title to %q", track, tp.Title))
+		}
+		isDefault := dispositionDefault(tp.Disposition)
+		if tp.Disposition != "" && tp.Action != ActionAttach && isDefault != tp.Track.Properties.DefaultTrack {
+			ret = append(ret, fmt.Sprintf("%s: set default flag to %v", track, isDefault))
+		}
+	}
+	return ret
+}
+
+// Timeout returns the maximum time to run ffmpeg: the TimeoutFactor option
+// times the duration of the input file (reencodeTimeoutFactor times more
+// when re-encoding video, plus the same again to decode the output with
+// VerifyDecode), plus minTimeout. Zero means no timeout.
+func (p Plan) Timeout() time.Duration {
+	if p.Options.TimeoutFactor <= 0 || p.Duration <= 0 {
+		return 0
+	}
+	factor := p.Options.TimeoutFactor
+	for _, tp := range p.Tracks {
+		if tp.Action == ActionReencode {
+			factor *= reencodeTimeoutFactor
+			break
+		}
+	}
+	if p.Options.Verify == VerifyDecode {
+		factor += p.Options.TimeoutFactor
+	}
+	return minTimeout + time.Duration(float64(p.Duration)*factor)
+}
+
+// trackCodecArgs returns the ffmpeg codec arguments for the track at the
+// given output position.
+func trackCodecArgs(tp TrackPlan, stream string, n int, opts Options) []string {
+	switch tp.Action {
+	case ActionTranscode:
+		return []string{
+			fmt.Sprintf("-c:%s:%d", stream, n), opts.AACEncoder,
+			fmt.Sprintf("-b:%s:%d", stream, n), opts.AudioBitrate,
+		}
+	case ActionReencode:
+		return videoEncodeArgs(n, tp.Codec, opts.VideoCRF, opts.VideoPreset)
+	}
+	return []string{fmt.Sprintf("-c:%s:%d", stream, n), "copy"}
+}
+
+// Command returns the ffmpeg command line to execute the plan.
+func (p Plan) Command() []string {
+	args := []string{
+		p.Options.path("ffmpeg"),
+		"-loglevel", "error",
+		"-nostats",
+		"-progress", "pipe:1",
+		"-i", p.Input,
+	}
+
+	// Sidecar files become additional inputs, numbered in order of appearance.
+	// Tracks in the main input are not in the map (input 0).
+	inputs := map[string]int{}
+	for _, tp := range p.Tracks {
+		if _, ok := inputs[tp.Track.File]; tp.Track.Sidecar && tp.Action != ActionDrop && !ok {
+			inputs[tp.Track.File] = len(inputs) + 1
+			args = append(args, "-i", tp.Track.File)
+		}
+	}
+	chaptersInput := len(inputs) + 1
+	if p.Chapters != "" {
+		args = append(args, "-f", "ffmetadata", "-i", p.chaptersFile())
+	}
+
+	args = append(args, metadataArgs(p.Metadata, chaptersInput)...)
+
+	// IMPORTANT: The -map command uses the INPUT track number while the
+	// per stream options use the relative OUTPUT track number.
+	outputs := map[string]int{}
+	for _, tp := range p.Tracks {
+		stream, ok := streamTypes[tp.Track.Type]
+		if tp.Action == ActionDrop || !ok {
+			continue
+		}
+		n := outputs[stream]
+		outputs[stream]++
+
+		args = append(args, "-map", fmt.Sprintf("%d:%d", inputs[tp.Track.File], tp.Track.ID))
+		args = append(args, trackCodecArgs(tp, stream, n, p.Options)...)
+		if tp.Disposition != "" {
+			args = append(args, fmt.Sprintf("-disposition:%s:%d", stream, n), tp.Disposition)
+		}
+		if tp.Track.Sidecar {
+			args = append(args, fmt.Sprintf("-metadata:s:%s:%d", stream, n), "language="+tp.Language)
+		}
+		if tp.Title != "" {
+			args = append(args, fmt.Sprintf("-metadata:s:%s:%d", stream, n), "title="+tp.Title)
+		}
+		if tp.Action == ActionAttach {
+			args = append(args, coverArgs(tp.Track, n)...)
+		}
+	}
+
+	// Final arguments.
+	args = append(args,
+		"-max_interleave_delta", "0",
+		"-y",
+		"-f", "matroska",
+		p.TempFile)
+
+	return args
+}
+
+// Execute runs the plan. The output is written to a temporary file, which
+// replaces the output file once ffmpeg finishes successfully. Plans created
+// with the DryRun option are not executed, and plans whose input files
+// changed since planning are refused. Unless disabled by the Verify option,
+// the output is probed and compared to the plan before replacing the input.
+// If the context is cancelled or the plan timeout expires, ffmpeg is killed,
+// the temporary file is removed and the error wraps ErrCancelled or
+// ErrTimeout.
+func Execute(ctx context.Context, plan Plan) error {
+	return ExecuteProgress(ctx, plan, nil)
+}
+
+// ExecuteProgress is like Execute, calling fn with the progress of ffmpeg
+// (if fn is not nil).
+func ExecuteProgress(ctx context.Context, plan Plan, fn ProgressFunc) error {
+	if err := plan.CheckInputs(); err != nil {
+		return err
+	}
+	if plan.Options.DryRun {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return commandError(ctx, ctx.Err())
+	}
+
+	dirname := filepath.Dir(plan.Output)
+	if err := os.MkdirAll(dirname, 0775); err != nil {
+		return fmt.Errorf("unable to create output directory: %s", dirname)
+	}
+
+	// Do not proceed if our temp file belongs to another instance. Stale
+	// temp files left by crashed runs are removed with CleanStale.
+	if err := lockTemp(plan); err != nil {
+		return err
+	}
+	defer unlockTemp(plan)
+
+	// Wait for free space before the timeout starts.
+	if err := waitForSpace(ctx, plan); err != nil {
+		return err
+	}
+	if timeout := plan.Timeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if plan.Chapters != "" {
+		if err := os.WriteFile(plan.chaptersFile(), []byte(plan.Chapters), 0644); err != nil {
+			return fmt.Errorf("unable to write chapters file: %w", err)
+		}
+		defer os.Remove(plan.chaptersFile())
+	}
+
+	// Execute the ffmpeg command. Progress comes in the standard output and
+	// errors go to stderr.
+	args := plan.Command()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("unable to run ffmpeg: %w", commandError(ctx, err))
+	}
+	_ = readProgress(stdout, Progress{Input: plan.Input, Duration: plan.Duration}, fn)
+
+	if err := cmd.Wait(); err != nil {
+		_ = os.Remove(plan.TempFile)
+		return fmt.Errorf("ffmpeg conversion failed for %s: %w", plan.Input, commandError(ctx, err))
+	}
+
+	if plan.Options.Verify != VerifyOff {
+		if err := verifyOutput(ctx, plan); err != nil {
+			_ = os.Remove(plan.TempFile)
+			return fmt.Errorf("output verification failed for %s, input left untouched: %w", plan.Input, err)
+		}
+	}
+
+	// Rename the output file back to the original name in the output directory
+	if err := os.Rename(plan.TempFile, plan.Output); err != nil {
+		return fmt.Errorf("failed to move '%s' to '%s': %v", plan.TempFile, plan.Output, err)
+	}
+	// If the input file is a .mp4 file. In this case, since we crated a mkv
+	// file during transcoding, rename it to .mkv
+	if strings.ToLower(filepath.Ext(plan.Input)) == ".mp4" {
+		if err := os.Rename(plan.Input, strings.TrimSuffix(plan.Input, ".mp4")+".mkv"); err != nil {
+			return fmt.Errorf("failed to move '%s' to '%s': %v", plan.TempFile, plan.Input, err)
+		}
+	}
+	return nil
+}
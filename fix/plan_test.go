@@ -0,0 +1,148 @@
+package fix
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNewPlan(t *testing.T) {
+	testCases := []struct {
+		name             string
+		tracks           []Track
+		outputDir        string
+		expectedOutput   string
+		expectedTempFile string
+		expectErr        bool
+	}{
+		{
+			name: "MKV file",
+			tracks: []Track{
+				{ID: 0, Type: "video", CodecID: "HEVC/H.265/MPEG-H", File: "dir/movie.mkv"},
+				{ID: 1, Type: "audio", CodecID: "E-AC-3", Properties: TrackProperties{Language: "eng"}, File: "dir/movie.mkv"},
+			},
+			expectedOutput:   "dir/movie.mkv",
+			expectedTempFile: "dir/movie_with_aac.mkv.TMP",
+		},
+		{
+			name: "Output directory",
+			tracks: []Track{
+				{ID: 0, Type: "video", CodecID: "HEVC/H.265/MPEG-H", File: "dir/movie.mp4"},
+			},
+			outputDir:        "out",
+			expectedOutput:   "out/movie.mp4",
+			expectedTempFile: "out/movie_with_aac.mp4.TMP",
+		},
+		{
+			name: "Not an MKV or MP4 file",
+			tracks: []Track{
+				{ID: 0, Type: "video", CodecID: "HEVC/H.265/MPEG-H", File: "dir/movie.avi"},
+			},
+			expectErr: true,
+		},
+		{
+			name: "Only sidecar tracks",
+			tracks: []Track{
+				{ID: 0, Type: "subtitles", CodecID: "SubRip/SRT", File: "dir/movie.en.srt", Sidecar: true},
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			opts.OutputDir = tc.outputDir
+			plan, err := NewPlan(context.Background(), tc.tracks, opts)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("expected error, but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if plan.Output != filepath.FromSlash(tc.expectedOutput) {
+				t.Errorf("expected output %q, got %q", tc.expectedOutput, plan.Output)
+			}
+			if plan.TempFile != filepath.FromSlash(tc.expectedTempFile) {
+				t.Errorf("expected temp file %q, got %q", tc.expectedTempFile, plan.TempFile)
+			}
+			if args := plan.Command(); args[len(args)-1] != plan.TempFile {
+				t.Errorf("expected ffmpeg to write to %q, got %q", plan.TempFile, args[len(args)-1])
+			}
+		})
+	}
+}
+
+func TestPlanJSON(t *testing.T) {
+	tracks := []Track{
+		{ID: 0, Type: "video", CodecID: "HEVC/H.265/MPEG-H", File: "movie.mkv", HDR: HDRInfo{HDR10: true}},
+		{ID: 1, Type: "audio", CodecID: "E-AC-3", Properties: TrackProperties{Language: "eng"}, File: "movie.mkv"},
+		{ID: 0, Type: "subtitles", CodecID: "SubRip/SRT", Properties: TrackProperties{Language: "por"}, File: "movie.pt.srt", Sidecar: true},
+	}
+	plan, err := NewPlan(context.Background(), tracks, DefaultOptions())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	data, err := json.Marshal(plan)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var replayed Plan
+	if err := json.Unmarshal(data, &replayed); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(replayed, plan) {
+		t.Errorf("expected:\n%+v\ngot:\n%+v", plan, replayed)
+	}
+	if !reflect.DeepEqual(replayed.Command(), plan.Command()) {
+		t.Errorf("expected:\n%v\ngot:\n%v", plan.Command(), replayed.Command())
+	}
+}
+
+func TestPlanChanges(t *testing.T) {
+	testCases := []struct {
+		name     string
+		tracks   []Track
+		expected []string
+	}{
+		{
+			name: "Nothing to do",
+			tracks: []Track{
+				{ID: 0, Type: "video", CodecID: "HEVC/H.265/MPEG-H", Properties: TrackProperties{DefaultTrack: true}, File: "movie.mkv"},
+				{ID: 1, Type: "audio", CodecID: "AAC", Properties: TrackProperties{Language: "eng", DefaultTrack: true}, File: "movie.mkv"},
+				{ID: 2, Type: "audio", CodecID: "AAC", Properties: TrackProperties{Language: "spa"}, File: "movie.mkv"},
+			},
+		},
+		{
+			name: "Transcode and fix flags",
+			tracks: []Track{
+				{ID: 0, Type: "video", CodecID: "HEVC/H.265/MPEG-H", Properties: TrackProperties{DefaultTrack: true}, File: "movie.mp4"},
+				{ID: 1, Type: "audio", CodecID: "E-AC-3", Properties: TrackProperties{Language: "spa", DefaultTrack: true}, File: "movie.mp4"},
+			},
+			expected: []string{
+				"convert MP4 to MKV",
+				"audio track 1: transcode (EAC3 --> AAC conversion)",
+				`audio track 1: set title to "AAC Audio (spa)"`,
+				"audio track 1: set default flag to false",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			plan, err := NewPlan(context.Background(), tc.tracks, DefaultOptions())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result := plan.Changes(); !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("expected:\n%v\ngot:\n%v", tc.expected, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+// Saved plans.
+//
+// Plans can be saved to a JSON file, reviewed (and edited) by a human, and
+// executed later. Each plan records the size and modification time of its
+// input files, so plans are not executed when the files changed after
+// planning.
+
+package fix
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// planFileVersion is the version of the saved plans format.
+const planFileVersion = 1
+
+// validActions lists the valid actions for each track type.
+var validActions = map[string][]string{
+	mkvVideoType: {ActionCopy, ActionReencode, ActionAttach, ActionDrop},
+	mkvAudioType: {ActionCopy, ActionTranscode, ActionDrop},
+	mkvSubType:   {ActionCopy, ActionDrop},
+}
+
+// FileStamp holds the size and modification time of a file.
+type FileStamp struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// planFile holds the top-level JSON structure of a saved plans file.
+type planFile struct {
+	Version int    `json:"version"`
+	Plans   []Plan `json:"plans"`
+}
+
+// stampFile returns the FileStamp of a file.
+func stampFile(path string) (FileStamp, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return FileStamp{}, err
+	}
+	return FileStamp{Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+// stampInputs returns the stamps of the input files of the tracks, or nil
+// if none of the files can be read.
+func stampInputs(tracks []Track) map[string]FileStamp {
+	var ret map[string]FileStamp
+	for _, track := range tracks {
+		if _, ok := ret[track.File]; ok || track.File == "" {
+			continue
+		}
+		stamp, err := stampFile(track.File)
+		if err != nil {
+			continue
+		}
+		if ret == nil {
+			ret = map[string]FileStamp{}
+		}
+		ret[track.File] = stamp
+	}
+	return ret
+}
+
+// CheckInputs returns an error if any of the input files changed (or
+// disappeared) since the plan was created.
+func (p Plan) CheckInputs() error {
+	for file, stamp := range p.Inputs {
+		current, err := stampFile(file)
+		if err != nil {
+			return fmt.Errorf("input file changed since planning: %w", err)
+		}
+		if current.Size != stamp.Size || !current.ModTime.Equal(stamp.ModTime) {
+			return fmt.Errorf("input file changed since planning: %s", file)
+		}
+	}
+	return nil
+}
+
+// Validate returns an error if the plan is not valid. This is useful to
+// check plans edited by hand. The temporary file must be named like the
+// ones created by NewPlan, in the output directory, and the inputs must
+// include the input file and every sidecar file the command reads.
+func (p Plan) Validate() error {
+	if p.Input == "" || p.Output == "" || p.TempFile == "" {
+		return fmt.Errorf("plan must have input, output and temp_file")
+	}
+	if err := p.Options.Validate(); err != nil {
+		return err
+	}
+	// The temporary file may be removed as stale, so it must be named and
+	// placed like the ones created by NewPlan.
+	tempFile := filepath.Clean(p.TempFile)
+	if !isTempName(tempFile) || filepath.Dir(tempFile) != filepath.Dir(filepath.Clean(p.Output)) {
+		return fmt.Errorf("invalid temp_file %s: must be named like <name>%s.mkv%s in the output directory", p.TempFile, outputSuffix, tempSuffix)
+	}
+	if tempFile == filepath.Clean(p.Input) || tempFile == filepath.Clean(p.Output) {
+		return fmt.Errorf("invalid temp_file %s: must differ from the input and output files", p.TempFile)
+	}
+	// All files read by the command must be in the inputs, or CheckInputs
+	// won't notice when they change.
+	if _, ok := p.Inputs[p.Input]; !ok {
+		return fmt.Errorf("input file %s missing from inputs", p.Input)
+	}
+	for _, tp := range p.Tracks {
+		actions, ok := validActions[tp.Track.Type]
+		if !ok {
+			return fmt.Errorf("track %d: invalid track type %q", tp.Track.ID, tp.Track.Type)
+		}
+		valid := false
+		for _, action := range actions {
+			valid = valid || action == tp.Action
+		}
+		if !valid {
+			return fmt.Errorf("track %d: invalid action %q for %s track", tp.Track.ID, tp.Action, tp.Track.Type)
+		}
+		if _, ok := videoEncoders[tp.Codec]; tp.Action == ActionReencode && !ok {
+			return fmt.Errorf("track %d: invalid video codec %q", tp.Track.ID, tp.Codec)
+		}
+		if tp.Action == ActionAttach && !isCoverArt(tp.Track) {
+			return fmt.Errorf("track %d: only cover art can be attached", tp.Track.ID)
+		}
+		if _, ok := p.Inputs[tp.Track.File]; tp.Track.Sidecar && tp.Action != ActionDrop && !ok {
+			return fmt.Errorf("track %d: sidecar file %s missing from inputs", tp.Track.ID, tp.Track.File)
+		}
+	}
+	return nil
+}
+
+// WritePlans writes the plans to w in JSON format.
+func WritePlans(w io.Writer, plans []Plan) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(planFile{Version: planFileVersion, Plans: plans})
+}
+
+// ReadPlans reads and validates the plans saved by WritePlans.
+func ReadPlans(r io.Reader) ([]Plan, error) {
+	var pf planFile
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&pf); err != nil {
+		return nil, fmt.Errorf("error parsing plans: %w", err)
+	}
+	if pf.Version != planFileVersion {
+		return nil, fmt.Errorf("unsupported plans version: %d", pf.Version)
+	}
+	for _, plan := range pf.Plans {
+		if err := plan.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", plan.Input, err)
+		}
+	}
+	return pf.Plans, nil
+}
@@ -0,0 +1,160 @@
+package fix
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSavedPlans(t *testing.T) {
+	dir := t.TempDir()
+	movie := filepath.Join(dir, "movie.mkv")
+	if err := os.WriteFile(movie, []byte("movie"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tracks := []Track{
+		{ID: 0, Type: "video", CodecID: "HEVC/H.265/MPEG-H", Properties: TrackProperties{Language: "und"}, File: movie},
+		{ID: 1, Type: "audio", CodecID: "E-AC-3", Properties: TrackProperties{Language: "eng"}, File: movie},
+		{ID: 2, Type: "audio", CodecID: "AAC", Properties: TrackProperties{Language: "spa"}, File: movie},
+	}
+	opts := DefaultOptions()
+	opts.Prune = true
+	plan, err := NewPlan(context.Background(), tracks, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := plan.CheckInputs(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := WritePlans(&buf, []Plan{plan}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Flip the Spanish track back to copy, as a human would.
+	edited := strings.Replace(buf.String(), `"action": "drop"`, `"action": "copy"`, 1)
+	plans, err := ReadPlans(strings.NewReader(edited))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(plans) != 1 {
+		t.Fatalf("expected 1 plan, got %d", len(plans))
+	}
+	if result, expected := decisions(plans[0].Tracks), []string{"video 0: copy", "audio 1: transcode", "audio 2: copy"}; !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected:\n%v\ngot:\n%v", expected, result)
+	}
+	if err := plans[0].CheckInputs(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Any change to the input makes the plan stale.
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(movie, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := plans[0].CheckInputs(); err == nil {
+		t.Errorf("expected error for a modified input, got none")
+	}
+}
+
+func TestPlanValidate(t *testing.T) {
+	base := Plan{
+		Input:    "movie.mkv",
+		Output:   "movie.mkv",
+		TempFile: "movie_with_aac.mkv.TMP",
+		Options:  DefaultOptions(),
+		Inputs:   map[string]FileStamp{"movie.mkv": {Size: 5}, "movie.en.srt": {Size: 3}},
+	}
+
+	testCases := []struct {
+		name      string
+		track     TrackPlan
+		inputs    map[string]FileStamp
+		tempFile  string
+		expectErr bool
+	}{
+		{
+			name:  "Copy audio",
+			track: TrackPlan{Track: Track{Type: "audio"}, Action: ActionCopy},
+		},
+		{
+			name:  "Re-encode video",
+			track: TrackPlan{Track: Track{Type: "video"}, Action: ActionReencode, Codec: "hevc"},
+		},
+		{
+			name:      "Transcode subtitles",
+			track:     TrackPlan{Track: Track{Type: "subtitles"}, Action: ActionTranscode},
+			expectErr: true,
+		},
+		{
+			name:      "Unknown action",
+			track:     TrackPlan{Track: Track{Type: "audio"}, Action: "keep"},
+			expectErr: true,
+		},
+		{
+			name:      "Re-encode to unknown codec",
+			track:     TrackPlan{Track: Track{Type: "video"}, Action: ActionReencode, Codec: "vp9"},
+			expectErr: true,
+		},
+		{
+			name:  "Copy sidecar",
+			track: TrackPlan{Track: Track{Type: "subtitles", File: "movie.en.srt", Sidecar: true}, Action: ActionCopy},
+		},
+		{
+			name:      "Copy sidecar missing from inputs",
+			track:     TrackPlan{Track: Track{Type: "subtitles", File: "movie.pt.srt", Sidecar: true}, Action: ActionCopy},
+			expectErr: true,
+		},
+		{
+			name:  "Drop sidecar missing from inputs",
+			track: TrackPlan{Track: Track{Type: "subtitles", File: "movie.pt.srt", Sidecar: true}, Action: ActionDrop},
+		},
+		{
+			name:      "Input missing from inputs",
+			track:     TrackPlan{Track: Track{Type: "audio"}, Action: ActionCopy},
+			inputs:    map[string]FileStamp{"other.mkv": {Size: 5}},
+			expectErr: true,
+		},
+		{
+			name:      "Unrelated temp file",
+			track:     TrackPlan{Track: Track{Type: "audio"}, Action: ActionCopy},
+			tempFile:  "important.txt",
+			expectErr: true,
+		},
+		{
+			name:      "Temp file in another directory",
+			track:     TrackPlan{Track: Track{Type: "audio"}, Action: ActionCopy},
+			tempFile:  "/tmp/movie_with_aac.mkv.TMP",
+			expectErr: true,
+		},
+		{
+			name:      "Attach a real video track",
+			track:     TrackPlan{Track: Track{Type: "video", CodecID: "HEVC/H.265/MPEG-H"}, Action: ActionAttach},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			plan := base
+			plan.Tracks = []TrackPlan{tc.track}
+			if tc.inputs != nil {
+				plan.Inputs = tc.inputs
+			}
+			if tc.tempFile != "" {
+				plan.TempFile = tc.tempFile
+			}
+			err := plan.Validate()
+			if tc.expectErr != (err != nil) {
+				t.Errorf("expected error=%v, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package fix
+
+import "time"
+
+// bootTime is not supported on this system.
+func bootTime() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+// processStarted is not supported on this system.
+func processStarted(pid int) (time.Time, bool) {
+	return time.Time{}, false
+}
@@ -0,0 +1,27 @@
+//go:build !unix
+
+package fix
+
+import (
+	"os"
+	"os/exec"
+)
+
+// processAlive returns true if a process with the given PID exists. On
+// Windows, FindProcess fails for processes that don't exist. Elsewhere it
+// always succeeds and the process is assumed to be alive.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
+
+// killProcessGroup does nothing on this system. Only the command itself is
+// killed when the context ends.
+func killProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,28 @@
+//go:build unix
+
+package fix
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+)
+
+// processAlive returns true if a process with the given PID exists.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// killProcessGroup runs cmd in a new process group, and kills the whole
+// group when the context ends, so the children started by a shell command
+// don't outlive it.
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
@@ -0,0 +1,87 @@
+// Progress reporting.
+//
+// ffmpeg runs with "-progress pipe:1", writing blocks of key=value lines to
+// its standard output. Each block ends with "progress=continue" (or
+// "progress=end" at the end) and becomes a Progress event.
+
+package fix
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Progress describes the progress of an ffmpeg run.
+type Progress struct {
+	Input    string        // Main input file.
+	Position time.Duration // Current position in the output.
+	Duration time.Duration // Duration of the input, or zero if unknown.
+	Speed    float64       // Processing speed, in multiples of real time.
+	Done     bool          // True in the last event.
+}
+
+// ProgressFunc receives progress events. It is called from the goroutine
+// reading the ffmpeg output and should return quickly.
+type ProgressFunc func(Progress)
+
+// Percent returns the percentage done (0-100), or -1 if unknown.
+func (p Progress) Percent() float64 {
+	switch {
+	case p.Done:
+		return 100
+	case p.Duration <= 0:
+		return -1
+	}
+	return min(100, 100*float64(p.Position)/float64(p.Duration))
+}
+
+// ETA returns the estimated time to finish, or -1 if unknown.
+func (p Progress) ETA() time.Duration {
+	switch {
+	case p.Done:
+		return 0
+	case p.Duration <= 0 || p.Speed <= 0:
+		return -1
+	}
+	remaining := max(0, p.Duration-p.Position)
+	return time.Duration(float64(remaining) / p.Speed).Round(time.Second)
+}
+
+// readProgress parses the ffmpeg progress output from r and calls fn for
+// every block. Progress contains the values common to all events. R is
+// always read to the end.
+func readProgress(r io.Reader, progress Progress, fn ProgressFunc) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "out_time_us":
+			if us, err := strconv.ParseInt(value, 10, 64); err == nil && us >= 0 {
+				progress.Position = time.Duration(us) * time.Microsecond
+			}
+		case "speed":
+			// E.g. "1.5x", or "N/A" at the start.
+			speed, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "x"), 64)
+			if err != nil {
+				speed = 0
+			}
+			progress.Speed = speed
+		case "progress":
+			progress.Done = value == "end"
+			if fn != nil {
+				fn(progress)
+			}
+		}
+	}
+	// Keep reading after a scan error (e.g. an overlong line), so ffmpeg
+	// doesn't block writing to a full pipe.
+	err := scanner.Err()
+	_, _ = io.Copy(io.Discard, r)
+	return err
+}
@@ -0,0 +1,98 @@
+package fix
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadProgress(t *testing.T) {
+	output := `frame=120
+fps=0.00
+out_time_us=5000000
+out_time=00:00:05.000000
+speed=N/A
+progress=continue
+frame=2400
+out_time_us=60000000
+speed=2.5x
+progress=continue
+out_time_us=120000000
+speed=3x
+progress=end
+`
+	var events []Progress
+	err := readProgress(strings.NewReader(output), Progress{Input: "movie.mkv", Duration: 2 * time.Minute}, func(p Progress) {
+		events = append(events, p)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []Progress{
+		{Input: "movie.mkv", Position: 5 * time.Second, Duration: 2 * time.Minute},
+		{Input: "movie.mkv", Position: time.Minute, Duration: 2 * time.Minute, Speed: 2.5},
+		{Input: "movie.mkv", Position: 2 * time.Minute, Duration: 2 * time.Minute, Speed: 3, Done: true},
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("expected:\n%+v\ngot:\n%+v", expected, events)
+	}
+}
+
+func TestReadProgressDrains(t *testing.T) {
+	// A line too long for the scanner stops parsing, but the rest of the
+	// output must still be read.
+	r := strings.NewReader("progress=continue\n" + strings.Repeat("x", 1<<20) + "\nprogress=end\n")
+	if err := readProgress(r, Progress{}, nil); err == nil {
+		t.Errorf("expected error, but got none")
+	}
+	if r.Len() != 0 {
+		t.Errorf("expected output to be drained, %d bytes left", r.Len())
+	}
+}
+
+func TestProgressPercentAndETA(t *testing.T) {
+	testCases := []struct {
+		name            string
+		progress        Progress
+		expectedPercent float64
+		expectedETA     time.Duration
+	}{
+		{
+			name:            "Halfway",
+			progress:        Progress{Position: time.Hour, Duration: 2 * time.Hour, Speed: 2},
+			expectedPercent: 50,
+			expectedETA:     30 * time.Minute,
+		},
+		{
+			name:            "Unknown speed",
+			progress:        Progress{Position: time.Hour, Duration: 4 * time.Hour},
+			expectedPercent: 25,
+			expectedETA:     -1,
+		},
+		{
+			name:            "Unknown duration",
+			progress:        Progress{Position: time.Hour, Speed: 2},
+			expectedPercent: -1,
+			expectedETA:     -1,
+		},
+		{
+			name:            "Done",
+			progress:        Progress{Position: time.Hour, Done: true},
+			expectedPercent: 100,
+			expectedETA:     0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if result := tc.progress.Percent(); result != tc.expectedPercent {
+				t.Errorf("percent: expected %v, got %v", tc.expectedPercent, result)
+			}
+			if result := tc.progress.ETA(); result != tc.expectedETA {
+				t.Errorf("ETA: expected %v, got %v", tc.expectedETA, result)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+// Sidecar file handling.
+//
+// Sidecar files are external subtitle and audio files living next to the
+// video file and sharing its base name, like "Movie.en.srt",
+// "Movie.pt.forced.srt" or "Movie.commentary.ac3". Language and flags are
+// inferred from the dot separated words between the base name and the
+// extension.
+
+package fix
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// sidecarTypes maps the supported sidecar file extensions to track types.
+var sidecarTypes = map[string]string{
+	".srt":  mkvSubType,
+	".ass":  mkvSubType,
+	".ssa":  mkvSubType,
+	".vtt":  mkvSubType,
+	".sup":  mkvSubType,
+	".ac3":  mkvAudioType,
+	".eac3": mkvAudioType,
+	".dts":  mkvAudioType,
+	".aac":  mkvAudioType,
+	".m4a":  mkvAudioType,
+	".mka":  mkvAudioType,
+	".flac": mkvAudioType,
+	".opus": mkvAudioType,
+	".mp3":  mkvAudioType,
+}
+
+// languageCodes maps ISO 639-1 and ISO 639-2/T language codes to the
+// ISO 639-2/B codes used in MKV files.
+var languageCodes = map[string]string{
+	"ar": "ara", "bg": "bul", "ca": "cat", "cs": "cze", "da": "dan",
+	"de": "ger", "el": "gre", "en": "eng", "es": "spa", "et": "est",
+	"fa": "per", "fi": "fin", "fr": "fre", "he": "heb", "hi": "hin",
+	"hr": "hrv", "hu": "hun", "id": "ind", "is": "ice", "it": "ita",
+	"ja": "jpn", "ko": "kor", "lt": "lit", "lv": "lav", "ms": "may",
+	"nb": "nob", "nl": "dut", "no": "nor", "pl": "pol", "pt": "por",
+	"ro": "rum", "ru": "rus", "sk": "slo", "sl": "slv", "sr": "srp",
+	"sv": "swe", "th": "tha", "tr": "tur", "uk": "ukr", "vi": "vie",
+	"zh": "chi",
+
+	"ces": "cze", "deu": "ger", "ell": "gre", "fas": "per", "fra": "fre",
+	"isl": "ice", "msa": "may", "nld": "dut", "ron": "rum", "slk": "slo",
+	"zho": "chi",
+}
+
+// sidecar holds the information inferred from a sidecar filename.
+type sidecar struct {
+	path            string
+	ttype           string
+	lang            string
+	forced          bool
+	commentary      bool
+	hearingImpaired bool
+}
+
+// normalizeLang returns the ISO 639-2/B code for a language code found in a
+// filename, or blank if the string is not a known language code. Region
+// suffixes like "pt-BR" are ignored.
+func normalizeLang(code string) string {
+	code, _, _ = strings.Cut(strings.ToLower(code), "-")
+	if lang, ok := languageCodes[code]; ok {
+		return lang
+	}
+	for _, lang := range languageCodes {
+		if lang == code {
+			return lang
+		}
+	}
+	return ""
+}
+
+// parseSidecarName parses a sidecar filename belonging to a video with the
+// given base name (without extension). Returns false if the file is not a
+// sidecar of that video.
+func parseSidecarName(base string, name string) (sidecar, bool) {
+	ext := strings.ToLower(filepath.Ext(name))
+	ttype, ok := sidecarTypes[ext]
+	if !ok || !strings.HasPrefix(name, base+".") {
+		return sidecar{}, false
+	}
+
+	sc := sidecar{ttype: ttype, lang: "und"}
+	tags := strings.Split(strings.TrimSuffix(strings.TrimPrefix(name, base+"."), filepath.Ext(name)), ".")
+
+	// "hi" is Hindi, unless another tag gives the language (Movie.en.hi.srt).
+	hiLang := true
+	for _, tag := range tags {
+		if t := strings.ToLower(tag); t != "hi" && normalizeLang(t) != "" {
+			hiLang = false
+		}
+	}
+	for _, tag := range tags {
+		switch t := strings.ToLower(tag); {
+		case t == "hi" && hiLang:
+			sc.lang = normalizeLang(t)
+		case t == "forced" || t == "foreign":
+			sc.forced = true
+		case t == "sdh" || t == "hi" || t == "cc":
+			sc.hearingImpaired = true
+		case t == "commentary" || t == "comment":
+			sc.commentary = true
+		default:
+			if lang := normalizeLang(tag); lang != "" {
+				sc.lang = lang
+			}
+		}
+	}
+	return sc, true
+}
+
+// findSidecars returns all sidecar files for the video file, sorted by name.
+func findSidecars(videoFile string) ([]sidecar, error) {
+	dir := filepath.Dir(videoFile)
+	base := strings.TrimSuffix(filepath.Base(videoFile), filepath.Ext(videoFile))
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read directory %s: %w", dir, err)
+	}
+
+	var ret []sidecar
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		sc, ok := parseSidecarName(base, entry.Name())
+		if !ok {
+			continue
+		}
+		sc.path = filepath.Join(dir, entry.Name())
+		ret = append(ret, sc)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].path < ret[j].path })
+	return ret, nil
+}
+
+// readSidecarTracks returns the tracks from all sidecar files of the video
+// file. Language and flags inferred from the filename override the ones
+// found in the sidecar file itself. Tracks of a different type than the one
+// implied by the file extension are ignored.
+func readSidecarTracks(videoFile string, readTracksFunc func(string) ([]Track, error)) ([]Track, error) {
+	sidecars, err := findSidecars(videoFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []Track
+	for _, sc := range sidecars {
+		tracks, err := readTracksFunc(sc.path)
+		if err != nil {
+			return nil, fmt.Errorf("sidecar %s: %w", sc.path, err)
+		}
+		for _, track := range tracks {
+			if track.Type != sc.ttype {
+				continue
+			}
+			track.File = sc.path
+			track.Sidecar = true
+			if sc.lang != "und" || track.Properties.Language == "" {
+				track.Properties.Language = sc.lang
+			}
+			track.Properties.ForcedTrack = track.Properties.ForcedTrack || sc.forced
+			track.Properties.FlagCommentary = track.Properties.FlagCommentary || sc.commentary
+			track.Properties.FlagHearingImpaired = track.Properties.FlagHearingImpaired || sc.hearingImpaired
+			ret = append(ret, track)
+		}
+	}
+	return ret, nil
+}
+
+// ProbeSidecars returns the tracks from all sidecar files of the video
+// file, ready to be appended to the tracks returned by Probe.
+func ProbeSidecars(ctx context.Context, videoFile string, opts Options) ([]Track, error) {
+	return readSidecarTracks(videoFile, func(path string) ([]Track, error) {
+		return Probe(ctx, path, opts)
+	})
+}
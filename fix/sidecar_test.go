@@ -0,0 +1,150 @@
+package fix
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseSidecarName(t *testing.T) {
+	testCases := []struct {
+		name     string
+		filename string
+		wantOK   bool
+		expected sidecar
+	}{
+		{
+			name:     "Two letter language",
+			filename: "Movie.en.srt",
+			wantOK:   true,
+			expected: sidecar{ttype: "subtitles", lang: "eng"},
+		},
+		{
+			name:     "Forced subtitles",
+			filename: "Movie.pt.forced.srt",
+			wantOK:   true,
+			expected: sidecar{ttype: "subtitles", lang: "por", forced: true},
+		},
+		{
+			name:     "Region suffix and SDH",
+			filename: "Movie.pt-BR.sdh.ass",
+			wantOK:   true,
+			expected: sidecar{ttype: "subtitles", lang: "por", hearingImpaired: true},
+		},
+		{
+			name:     "Hindi",
+			filename: "Movie.hi.srt",
+			wantOK:   true,
+			expected: sidecar{ttype: "subtitles", lang: "hin"},
+		},
+		{
+			name:     "Hearing impaired with language",
+			filename: "Movie.en.hi.srt",
+			wantOK:   true,
+			expected: sidecar{ttype: "subtitles", lang: "eng", hearingImpaired: true},
+		},
+		{
+			name:     "Three letter terminology code",
+			filename: "Movie.deu.srt",
+			wantOK:   true,
+			expected: sidecar{ttype: "subtitles", lang: "ger"},
+		},
+		{
+			name:     "Commentary audio without language",
+			filename: "Movie.commentary.AC3",
+			wantOK:   true,
+			expected: sidecar{ttype: "audio", lang: "und", commentary: true},
+		},
+		{
+			name:     "No tags",
+			filename: "Movie.srt",
+			wantOK:   true,
+			expected: sidecar{ttype: "subtitles", lang: "und"},
+		},
+		{
+			name:     "Different base name",
+			filename: "Movie2.en.srt",
+		},
+		{
+			name:     "Unsupported extension",
+			filename: "Movie.en.nfo",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, ok := parseSidecarName("Movie", tc.filename)
+			if ok != tc.wantOK {
+				t.Fatalf("expected ok=%v, got %v", tc.wantOK, ok)
+			}
+			if !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("expected:\n%+v\ngot:\n%+v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestReadSidecarTracks(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"Movie.mkv", "Movie.en.srt", "Movie.pt.forced.srt", "Movie.ac3", "Other.en.srt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Fake mkvmerge: sidecars contain one track of the type given by the extension.
+	readTracks := func(path string) ([]Track, error) {
+		ttype := sidecarTypes[filepath.Ext(path)]
+		codec := "SubRip/SRT"
+		if ttype == "audio" {
+			codec = "E-AC-3"
+		}
+		return []Track{{ID: 0, Type: ttype, CodecID: codec}}, nil
+	}
+
+	expected := []Track{
+		{ID: 0, Type: "audio", CodecID: "E-AC-3", Properties: TrackProperties{Language: "und"}, File: filepath.Join(dir, "Movie.ac3"), Sidecar: true},
+		{ID: 0, Type: "subtitles", CodecID: "SubRip/SRT", Properties: TrackProperties{Language: "eng"}, File: filepath.Join(dir, "Movie.en.srt"), Sidecar: true},
+		{ID: 0, Type: "subtitles", CodecID: "SubRip/SRT", Properties: TrackProperties{Language: "por", ForcedTrack: true}, File: filepath.Join(dir, "Movie.pt.forced.srt"), Sidecar: true},
+	}
+
+	result, err := readSidecarTracks(filepath.Join(dir, "Movie.mkv"), readTracks)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected:\n%+v\ngot:\n%+v", expected, result)
+	}
+}
+
+func TestPlanCommandSidecars(t *testing.T) {
+	tracks := []Track{
+		{ID: 0, Type: "video", CodecID: "V_MPEG4/ISO/AVC", File: "input.mkv"},
+		{ID: 1, Type: "audio", CodecID: "AAC", Properties: TrackProperties{Language: "eng"}, File: "input.mkv"},
+		{ID: 0, Type: "audio", CodecID: "AC-3", Properties: TrackProperties{Language: "eng", FlagCommentary: true}, File: "input.commentary.ac3", Sidecar: true},
+		{ID: 0, Type: "subtitles", CodecID: "SubRip/SRT", Properties: TrackProperties{Language: "eng"}, File: "input.en.srt", Sidecar: true},
+		{ID: 0, Type: "subtitles", CodecID: "SubRip/SRT", Properties: TrackProperties{Language: "por", ForcedTrack: true}, File: "input.pt.forced.srt", Sidecar: true},
+	}
+	expected := []string{
+		"ffmpeg", "-loglevel", "error", "-nostats", "-progress", "pipe:1", "-i", "input.mkv",
+		"-i", "input.commentary.ac3", "-i", "input.en.srt", "-i", "input.pt.forced.srt",
+		"-map_chapters", "0", "-map_metadata", "0",
+		"-map", "0:0", "-c:v:0", "copy", "-disposition:v:0", "default",
+		"-map", "0:1", "-c:a:0", "copy", "-disposition:a:0", "default",
+		"-map", "1:0", "-c:a:1", "copy", "-disposition:a:1", "default+comment", "-metadata:s:a:1", "language=eng",
+		"-map", "2:0", "-c:s:0", "copy", "-disposition:s:0", "default", "-metadata:s:s:0", "language=eng",
+		"-map", "3:0", "-c:s:1", "copy", "-disposition:s:1", "-default+forced", "-metadata:s:s:1", "language=por",
+		"-max_interleave_delta", "0", "-y", "-f", "matroska", "input_with_aac.mkv.TMP",
+	}
+
+	plan, err := NewPlan(context.Background(), tracks, DefaultOptions())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	result := plan.Command()
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected:\n%v\ngot:\n%v", expected, result)
+	}
+}
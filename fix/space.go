@@ -0,0 +1,126 @@
+// Free space check.
+//
+// Before ffmpeg starts, the size of the output is estimated and compared to
+// the space available in the output directory. The estimate is
+// conservative: the sizes of all input files used, plus the size of every
+// new AAC track (the tracks they replace are not subtracted).
+
+package fix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Values for Options.SpacePolicy.
+const (
+	SpacePolicyRefuse = "refuse"
+	SpacePolicyWait   = "wait"
+)
+
+// spaceCheckInterval is the time between free space checks when waiting.
+const spaceCheckInterval = time.Minute
+
+var (
+	// ErrNoSpace is returned when there is not enough free space for the
+	// output file.
+	ErrNoSpace = errors.New("not enough free space")
+
+	errNoStatfs = errors.New("free space check not supported")
+)
+
+// parseBitrate parses an ffmpeg bitrate (e.g. "256k" or "1.5M") and returns
+// the value in bits per second.
+func parseBitrate(s string) (int64, error) {
+	num, mult := s, 1.0
+	switch {
+	case strings.HasSuffix(s, "k"):
+		num, mult = strings.TrimSuffix(s, "k"), 1e3
+	case strings.HasSuffix(s, "M"):
+		num, mult = strings.TrimSuffix(s, "M"), 1e6
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid bitrate: %q", s)
+	}
+	return int64(v * mult), nil
+}
+
+// formatSize formats a size in bytes for humans.
+func formatSize(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// EstimatedSize returns the estimated size of the output file, in bytes.
+func (p Plan) EstimatedSize() uint64 {
+	files := map[string]bool{p.Input: true}
+	var size uint64
+	for _, tp := range p.Tracks {
+		if tp.Action != ActionDrop {
+			files[tp.Track.File] = true
+		}
+		if tp.Action == ActionTranscode && p.Duration > 0 {
+			if bps, err := parseBitrate(p.Options.AudioBitrate); err == nil {
+				size += uint64(float64(bps) / 8 * p.Duration.Seconds())
+			}
+		}
+	}
+	for file := range files {
+		if stamp, err := stampFile(file); err == nil {
+			size += uint64(stamp.Size)
+		}
+	}
+	return size
+}
+
+// CheckSpace returns an error wrapping ErrNoSpace if the filesystem of the
+// output directory does not have room for the estimated size of the output
+// plus the SpaceMargin option (a percentage). The check is skipped on
+// systems where the free space can't be found.
+func (p Plan) CheckSpace() error {
+	dir := filepath.Dir(p.TempFile)
+	free, err := freeSpace(dir)
+	if errors.Is(err, errNoStatfs) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to check free space in %s: %w", dir, err)
+	}
+	// Float math, so large files or margins can't overflow.
+	need := float64(p.EstimatedSize()) * (1 + float64(p.Options.SpaceMargin)/100)
+	if float64(free) < need {
+		return fmt.Errorf("%w in %s: need %s (including %d%% margin), have %s", ErrNoSpace, dir, formatSize(uint64(min(need, math.MaxInt64))), p.Options.SpaceMargin, formatSize(free))
+	}
+	return nil
+}
+
+// waitForSpace checks the free space for the plan. With SpacePolicyWait,
+// it waits until enough space is available or the context ends.
+func waitForSpace(ctx context.Context, p Plan) error {
+	for {
+		err := p.CheckSpace()
+		if err == nil || !errors.Is(err, ErrNoSpace) || p.Options.SpacePolicy != SpacePolicyWait {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for free space: %w", commandError(ctx, ctx.Err()))
+		case <-time.After(spaceCheckInterval):
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package fix
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseBitrate(t *testing.T) {
+	testCases := []struct {
+		input     string
+		expected  int64
+		expectErr bool
+	}{
+		{input: "256k", expected: 256000},
+		{input: "1.5M", expected: 1500000},
+		{input: "128000", expected: 128000},
+		{input: "fast", expectErr: true},
+		{input: "-1k", expectErr: true},
+		{input: "", expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			result, err := parseBitrate(tc.input)
+			if tc.expectErr {
+				if err == nil {
+					t.Fatalf("expected error, but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if result != tc.expected {
+				t.Errorf("expected %d, got %d", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	testCases := []struct {
+		input    uint64
+		expected string
+	}{
+		{input: 0, expected: "0 B"},
+		{input: 1023, expected: "1023 B"},
+		{input: 1536, expected: "1.5 KiB"},
+		{input: 5 << 30, expected: "5.0 GiB"},
+	}
+
+	for _, tc := range testCases {
+		if result := formatSize(tc.input); result != tc.expected {
+			t.Errorf("formatSize(%d): expected %q, got %q", tc.input, tc.expected, result)
+		}
+	}
+}
+
+func TestEstimatedSize(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "movie.mkv")
+	sidecar := filepath.Join(dir, "movie.en.srt")
+	dropped := filepath.Join(dir, "movie.fr.srt")
+	for file, size := range map[string]int{input: 1000, sidecar: 100, dropped: 10} {
+		if err := os.WriteFile(file, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	plan := Plan{
+		Input:    input,
+		Duration: 10 * time.Second,
+		Options:  Options{AudioBitrate: "8k"},
+		Tracks: []TrackPlan{
+			{Track: Track{Type: "video", File: input}, Action: ActionCopy},
+			{Track: Track{Type: "audio", File: input}, Action: ActionTranscode},
+			{Track: Track{Type: "subtitles", File: sidecar, Sidecar: true}, Action: ActionCopy},
+			{Track: Track{Type: "subtitles", File: dropped, Sidecar: true}, Action: ActionDrop},
+		},
+	}
+	// Input and sidecar files, plus 10 seconds of 8 kbit/s audio.
+	if result, expected := plan.EstimatedSize(), uint64(1000+100+10000); result != expected {
+		t.Errorf("expected %d, got %d", expected, result)
+	}
+}
+
+func TestCheckSpace(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "movie.mkv")
+	if err := os.WriteFile(input, make([]byte, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	plan := Plan{
+		Input:    input,
+		TempFile: filepath.Join(dir, "movie_with_aac.mkv.TMP"),
+		Options:  Options{SpaceMargin: 10},
+		Tracks:   []TrackPlan{{Track: Track{Type: "video", File: input}, Action: ActionCopy}},
+	}
+	if _, err := freeSpace(dir); errors.Is(err, errNoStatfs) {
+		t.Skip("free space check not supported")
+	}
+	if err := plan.CheckSpace(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// No filesystem has room for ten thousand copies of a 1 TiB input
+	// (sparse, so it takes no space). With the second margin, 1 TiB times
+	// (100 + margin) is exactly 2^64, which wraps to zero in integer math.
+	if err := os.Truncate(input, 1<<40); err != nil {
+		t.Skipf("unable to create a sparse file: %v", err)
+	}
+	for _, margin := range []int{1e6, 1<<24 - 100} {
+		plan.Options.SpaceMargin = margin
+		if err := plan.CheckSpace(); !errors.Is(err, ErrNoSpace) {
+			t.Errorf("margin %d: expected ErrNoSpace, got %v", margin, err)
+		}
+	}
+}
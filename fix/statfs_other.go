@@ -0,0 +1,9 @@
+//go:build !(linux || darwin || freebsd)
+
+package fix
+
+// freeSpace is not supported on this system. The free space check is
+// skipped.
+func freeSpace(dir string) (uint64, error) {
+	return 0, errNoStatfs
+}
@@ -0,0 +1,157 @@
+// Track title generation.
+//
+// Audio and subtitle track titles can be generated from a template using
+// the language, codec, channels and flags of each track. Templates contain
+// placeholders in braces, like "{Language} {Codec}". Any text inside the
+// braces is only included when the placeholder has a value, so
+// "{ (Commentary)}" adds " (Commentary)" to commentary tracks only.
+
+package fix
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// Values for Options.Titles.
+	TitlesOff  = "off"
+	TitlesAll  = "all"
+	TitlesJunk = "junk"
+
+	// DefaultTitleTemplate is the default template for track titles.
+	DefaultTitleTemplate = "{Language} {Codec} {Channels}{ (Commentary)}{ (Forced)}{ (SDH)}"
+)
+
+var (
+	// placeholderRe matches a placeholder group in a title template.
+	placeholderRe = regexp.MustCompile(`\{([^{}]*)\}`)
+	// wordRe matches the words inside a placeholder group.
+	wordRe = regexp.MustCompile(`[A-Za-z]+`)
+	// junkTitleRe matches track titles containing URLs, release group tags
+	// and encoding details.
+	junkTitleRe = regexp.MustCompile(`(?i)(www\.|https?://|\.(com|net|org|to|tv)\b|[@\[\]{}]|\b(x26[45]|h\.?26[45]|\d{3,4}p|web-?dl|webrip|bluray|rarbg|yts|yify|eztv)\b)`)
+)
+
+// languageNames maps ISO 639-2/B language codes to English names.
+var languageNames = map[string]string{
+	"ara": "Arabic", "bul": "Bulgarian", "cat": "Catalan", "cze": "Czech",
+	"dan": "Danish", "dut": "Dutch", "eng": "English", "est": "Estonian",
+	"fin": "Finnish", "fre": "French", "ger": "German", "gre": "Greek",
+	"heb": "Hebrew", "hin": "Hindi", "hrv": "Croatian", "hun": "Hungarian",
+	"ice": "Icelandic", "ind": "Indonesian", "ita": "Italian", "jpn": "Japanese",
+	"kor": "Korean", "lav": "Latvian", "lit": "Lithuanian", "may": "Malay",
+	"nob": "Norwegian", "nor": "Norwegian", "per": "Persian", "pol": "Polish",
+	"por": "Portuguese", "rum": "Romanian", "rus": "Russian", "slo": "Slovak",
+	"slv": "Slovenian", "spa": "Spanish", "srp": "Serbian", "swe": "Swedish",
+	"tha": "Thai", "tur": "Turkish", "ukr": "Ukrainian", "vie": "Vietnamese",
+	"chi": "Chinese",
+}
+
+// codecNames maps mkvmerge codec names to short names used in titles.
+var codecNames = map[string]string{
+	"AC-3":                         "AC3",
+	"E-AC-3":                       "EAC3",
+	"DTS-HD Master Audio":          "DTS-HD MA",
+	"DTS-HD High Resolution Audio": "DTS-HD HRA",
+	"SubRip/SRT":                   "SRT",
+	"SubStationAlpha":              "ASS",
+	"HDMV PGS":                     "PGS",
+	"VobSub":                       "VobSub",
+}
+
+// channelLayouts maps channel counts to the usual layout names.
+var channelLayouts = map[int]string{
+	1: "Mono",
+	2: "2.0",
+	3: "2.1",
+	6: "5.1",
+	7: "6.1",
+	8: "7.1",
+}
+
+// shortCodecName returns the short name of a codec.
+func shortCodecName(codec string) string {
+	if name, ok := codecNames[codec]; ok {
+		return name
+	}
+	return codec
+}
+
+// looksLikeJunk returns true if the track title is empty, too long, or has
+// URLs, release group tags or encoding details.
+func looksLikeJunk(title string) bool {
+	title = strings.TrimSpace(title)
+	return title == "" || len(title) > 60 || junkTitleRe.MatchString(title)
+}
+
+// titleVars returns the values of the template placeholders for a track.
+// Codec is the codec of the track in the output file.
+func titleVars(track Track, codec string) map[string]string {
+	vars := map[string]string{
+		"Lang":     track.Properties.Language,
+		"Language": languageNames[track.Properties.Language],
+		"Codec":    shortCodecName(codec),
+	}
+	if n := track.Properties.AudioChannels; n > 0 {
+		vars["Channels"] = channelLayouts[n]
+		if vars["Channels"] == "" {
+			vars["Channels"] = fmt.Sprintf("%dch", n)
+		}
+	}
+	if track.Properties.FlagCommentary {
+		vars["Commentary"] = "Commentary"
+	}
+	if track.Properties.ForcedTrack {
+		vars["Forced"] = "Forced"
+	}
+	if track.Properties.FlagHearingImpaired {
+		vars["SDH"] = "SDH"
+	}
+	return vars
+}
+
+// renderTitle expands the placeholders in the template. Groups whose
+// placeholder has no value are removed and runs of spaces are collapsed.
+func renderTitle(template string, vars map[string]string) string {
+	known := map[string]bool{
+		"Lang": true, "Language": true, "Codec": true, "Channels": true,
+		"Commentary": true, "Forced": true, "SDH": true,
+	}
+	out := placeholderRe.ReplaceAllStringFunc(template, func(group string) string {
+		content := group[1 : len(group)-1]
+		for _, word := range wordRe.FindAllString(content, -1) {
+			if known[word] {
+				if vars[word] == "" {
+					return ""
+				}
+				return strings.Replace(content, word, vars[word], 1)
+			}
+		}
+		return group
+	})
+	return strings.Join(strings.Fields(out), " ")
+}
+
+// trackTitle returns the generated title for an audio or subtitle track
+// and true, or false if the title should be left alone. Codec is the codec
+// of the track in the output and transcoded tells if the track changes codec,
+// in which case the old title is always replaced. Mode is one of TitlesOff,
+// TitlesAll or TitlesJunk.
+func trackTitle(track Track, codec string, transcoded bool, mode string, template string) (string, bool) {
+	switch mode {
+	case TitlesAll:
+	case TitlesJunk:
+		if !transcoded && !looksLikeJunk(track.Properties.TrackName) {
+			return "", false
+		}
+	default:
+		return "", false
+	}
+	title := renderTitle(template, titleVars(track, codec))
+	if title == "" || title == track.Properties.TrackName {
+		return "", false
+	}
+	return title, true
+}
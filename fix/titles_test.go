@@ -0,0 +1,113 @@
+package fix
+
+import (
+	"testing"
+)
+
+func TestRenderTitle(t *testing.T) {
+	testCases := []struct {
+		name     string
+		track    Track
+		codec    string
+		template string
+		expected string
+	}{
+		{
+			name:     "Audio track",
+			track:    Track{Properties: TrackProperties{Language: "eng", AudioChannels: 6}},
+			codec:    "E-AC-3",
+			template: DefaultTitleTemplate,
+			expected: "English EAC3 5.1",
+		},
+		{
+			name:     "Commentary track",
+			track:    Track{Properties: TrackProperties{Language: "eng", AudioChannels: 2, FlagCommentary: true}},
+			codec:    "AAC",
+			template: "{Language} {Codec} {Channels}{ (Commentary)}",
+			expected: "English AAC 2.0 (Commentary)",
+		},
+		{
+			name:     "Forced subtitles, no channels",
+			track:    Track{Properties: TrackProperties{Language: "por", ForcedTrack: true}},
+			codec:    "SubRip/SRT",
+			template: DefaultTitleTemplate,
+			expected: "Portuguese SRT (Forced)",
+		},
+		{
+			name:     "Unknown language and layout",
+			track:    Track{Properties: TrackProperties{Language: "und", AudioChannels: 4}},
+			codec:    "FLAC",
+			template: "{Language} {Codec} {Channels} [{Lang}]",
+			expected: "FLAC 4ch [und]",
+		},
+		{
+			name:     "Unknown placeholders are kept",
+			track:    Track{Properties: TrackProperties{Language: "eng"}},
+			codec:    "AAC",
+			template: "{Codec} {Foo}",
+			expected: "AAC {Foo}",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := renderTitle(tc.template, titleVars(tc.track, tc.codec))
+			if result != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestLooksLikeJunk(t *testing.T) {
+	testCases := []struct {
+		title    string
+		expected bool
+	}{
+		{"", true},
+		{"English", false},
+		{"Director's Commentary", false},
+		{"Dolby Digital 5.1", false},
+		{"www.example.com", true},
+		{"[GRP] English", true},
+		{"Movie.2020.1080p.WEB-DL.x264", true},
+		{"Surround 5.1 encoded by someone@somewhere", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.title, func(t *testing.T) {
+			if result := looksLikeJunk(tc.title); result != tc.expected {
+				t.Errorf("expected %v, got %v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestTrackTitle(t *testing.T) {
+	junk := Track{Properties: TrackProperties{Language: "eng", AudioChannels: 2, TrackName: "x264-GRP"}}
+	clean := Track{Properties: TrackProperties{Language: "eng", AudioChannels: 2, TrackName: "Stereo"}}
+
+	testCases := []struct {
+		name       string
+		mode       string
+		track      Track
+		transcoded bool
+		expected   string
+		expectedOK bool
+	}{
+		{name: "Off", mode: "off", track: junk},
+		{name: "All, clean title", mode: "all", track: clean, expected: "English AAC 2.0", expectedOK: true},
+		{name: "Junk only, clean title", mode: "junk", track: clean},
+		{name: "Junk only, junk title", mode: "junk", track: junk, expected: "English AAC 2.0", expectedOK: true},
+		{name: "Junk only, transcoded", mode: "junk", track: clean, transcoded: true, expected: "English AAC 2.0", expectedOK: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, ok := trackTitle(tc.track, "AAC", tc.transcoded, tc.mode, DefaultTitleTemplate)
+			if ok != tc.expectedOK || result != tc.expected {
+				t.Errorf("expected (%q, %v), got (%q, %v)", tc.expected, tc.expectedOK, result, ok)
+			}
+		})
+	}
+}
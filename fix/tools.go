@@ -0,0 +1,258 @@
+// External programs.
+//
+// ProbeTools finds mkvmerge, ffmpeg and ffprobe, reads their versions and
+// the encoders compiled into ffmpeg. The results decide which features can
+// be used: options needing a missing encoder are refused before any file
+// is processed.
+//
+// Each program is searched, in order, in the path set in the options, the
+// VIDEOFIX_<NAME> environment variable (e.g. VIDEOFIX_FFMPEG), the directory
+// of the running executable (for bundled programs) and the PATH.
+
+package fix
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ToolPaths holds the paths to the external programs. Blank paths are
+// searched as described in the package documentation.
+type ToolPaths struct {
+	MKVMerge string `json:"mkvmerge,omitempty"`
+	FFmpeg   string `json:"ffmpeg,omitempty"`
+	FFprobe  string `json:"ffprobe,omitempty"`
+}
+
+// path returns the path to the named program (mkvmerge, ffmpeg or
+// ffprobe), or the name itself to search it in the PATH.
+func (t ToolPaths) path(name string) string {
+	configured := map[string]string{"mkvmerge": t.MKVMerge, "ffmpeg": t.FFmpeg, "ffprobe": t.FFprobe}
+	if p := configured[name]; p != "" {
+		return p
+	}
+	if p := os.Getenv("VIDEOFIX_" + strings.ToUpper(name)); p != "" {
+		return p
+	}
+	if exe, err := os.Executable(); err == nil {
+		bundled := filepath.Join(filepath.Dir(exe), name)
+		if fi, err := os.Stat(bundled); err == nil && fi.Mode().IsRegular() && fi.Mode()&0111 != 0 {
+			return bundled
+		}
+	}
+	return name
+}
+
+// Tool holds information about an external program.
+type Tool struct {
+	Name       string
+	Package    string // Package containing the program.
+	Path       string // Full path, blank if not found.
+	Version    string // Version, blank if unknown.
+	MinVersion string // Minimum supported version.
+	Err        error  // Why the program can't be used, or nil.
+}
+
+// Feature describes an optional feature and whether it can be used.
+type Feature struct {
+	Name    string
+	Encoder string // ffmpeg encoder required by the feature.
+	Enabled bool
+}
+
+// Capabilities holds the external programs and the ffmpeg encoders
+// available in the system.
+type Capabilities struct {
+	Tools    []Tool
+	Encoders map[string]bool // Encoders of interest and their availability.
+}
+
+// requiredTools lists the external programs, the command line to print their
+// versions and a regular expression to parse it.
+var requiredTools = []struct {
+	name       string
+	pkg        string
+	versionArg string
+	versionRe  *regexp.Regexp
+	minVersion string
+}{
+	// JSON identification output (-F json) requires mkvmerge 9.0.
+	{"mkvmerge", "mkvtoolnix", "--version", regexp.MustCompile(`^mkvmerge v(\d+(?:\.\d+)*)`), "9.0"},
+	// The +flag/-flag disposition syntax requires ffmpeg 5.0.
+	{"ffmpeg", "ffmpeg", "-version", regexp.MustCompile(`^ffmpeg version n?(\d+(?:\.\d+)*)`), "5.0"},
+	{"ffprobe", "ffmpeg", "-version", regexp.MustCompile(`^ffprobe version n?(\d+(?:\.\d+)*)`), "5.0"},
+}
+
+// aacEncoders lists the ffmpeg encoders that can be used to produce AAC.
+var aacEncoders = []string{"aac", "libfdk_aac"}
+
+// knownEncoders lists the ffmpeg encoders reported by ProbeTools.
+var knownEncoders = []string{"aac", "libfdk_aac", "libopus", "libx264", "libx265"}
+
+// compareVersions compares two dotted version numbers and returns -1, 0 or
+// 1. Missing components are zero.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < max(len(as), len(bs)); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
+
+// parseVersion returns the version in the first line of the output of a
+// program, or blank if it can't be found. Development builds of ffmpeg
+// (e.g. "ffmpeg version N-113000-g1234") have no version number.
+func parseVersion(output []byte, re *regexp.Regexp) string {
+	line, _, _ := bytes.Cut(output, []byte("\n"))
+	m := re.FindSubmatch(bytes.TrimSpace(line))
+	if m == nil {
+		return ""
+	}
+	return string(m[1])
+}
+
+// parseEncoders parses the output of "ffmpeg -encoders" and returns the
+// names of the encoders.
+func parseEncoders(output []byte) map[string]bool {
+	ret := map[string]bool{}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	inList := false
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		switch {
+		case len(fields) == 1 && strings.HasPrefix(fields[0], "---"):
+			inList = true
+		case inList && len(fields) >= 2:
+			ret[fields[1]] = true
+		}
+	}
+	return ret
+}
+
+// ProbeTools returns the capabilities of the external programs in the
+// paths set in the options. Problems are recorded in the Err field of each
+// tool.
+func ProbeTools(ctx context.Context, opts Options) Capabilities {
+	caps := Capabilities{Encoders: map[string]bool{}}
+	for _, rt := range requiredTools {
+		tool := Tool{Name: rt.name, Package: rt.pkg, MinVersion: rt.minVersion}
+		name := opts.path(rt.name)
+		path, err := exec.LookPath(name)
+		switch {
+		case err != nil && name == rt.name:
+			tool.Err = fmt.Errorf("%s not found. Please install the %s package", rt.name, rt.pkg)
+		case err != nil:
+			tool.Err = fmt.Errorf("%s not found: %s", rt.name, name)
+		}
+		if err != nil {
+			caps.Tools = append(caps.Tools, tool)
+			continue
+		}
+		tool.Path = path
+
+		output, err := probeOutput(ctx, path, rt.versionArg)
+		if err != nil {
+			tool.Err = err
+		}
+		tool.Version = parseVersion(output, rt.versionRe)
+		if tool.Err == nil && tool.Version != "" && compareVersions(tool.Version, rt.minVersion) < 0 {
+			tool.Err = fmt.Errorf("%s version %s is too old (need %s or newer)", rt.name, tool.Version, rt.minVersion)
+		}
+		caps.Tools = append(caps.Tools, tool)
+	}
+
+	if path := caps.toolPath("ffmpeg"); path != "" {
+		output, err := probeOutput(ctx, path, "-hide_banner", "-encoders")
+		if err == nil {
+			encoders := parseEncoders(output)
+			for _, name := range knownEncoders {
+				caps.Encoders[name] = encoders[name]
+			}
+		}
+	}
+	return caps
+}
+
+// toolPath returns the path to a usable tool, or blank.
+func (c Capabilities) toolPath(name string) string {
+	for _, tool := range c.Tools {
+		if tool.Name == name && tool.Err == nil {
+			return tool.Path
+		}
+	}
+	return ""
+}
+
+// Version returns the version of a usable tool, or blank if unknown.
+func (c Capabilities) Version(name string) string {
+	for _, tool := range c.Tools {
+		if tool.Name == name && tool.Err == nil {
+			return tool.Version
+		}
+	}
+	return ""
+}
+
+// Check returns an error if any of the required programs is missing or too
+// old.
+func (c Capabilities) Check() error {
+	for _, tool := range c.Tools {
+		if tool.Err != nil {
+			return tool.Err
+		}
+	}
+	return nil
+}
+
+// Features returns the optional features and whether they are enabled.
+func (c Capabilities) Features() []Feature {
+	var ret []Feature
+	for _, encoder := range aacEncoders {
+		ret = append(ret, Feature{Name: "AAC transcoding", Encoder: encoder})
+	}
+	ret = append(ret,
+		Feature{Name: "HEVC re-encoding", Encoder: videoEncoders["hevc"]},
+		Feature{Name: "H.264 re-encoding", Encoder: videoEncoders["h264"]})
+	for i := range ret {
+		ret[i].Enabled = c.Encoders[ret[i].Encoder]
+	}
+	return ret
+}
+
+// CheckOptions returns an error if the options need a feature that is not
+// enabled.
+func (c Capabilities) CheckOptions(opts Options) error {
+	if len(opts.Transcode) > 0 && !c.Encoders[opts.AACEncoder] {
+		return fmt.Errorf("AAC encoder %q is not available in ffmpeg", opts.AACEncoder)
+	}
+	if encoder := videoEncoders[opts.VideoCodec]; len(opts.VideoReencode) > 0 && !c.Encoders[encoder] {
+		return fmt.Errorf("video encoder %q is not available in ffmpeg", encoder)
+	}
+	return nil
+}
+
+// CheckRequirements returns an error if any of the required programs
+// are not installed in the system, or are too old.
+func CheckRequirements(ctx context.Context, opts Options) error {
+	return ProbeTools(ctx, opts).Check()
+}
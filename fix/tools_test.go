@@ -0,0 +1,133 @@
+package fix
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	testCases := []struct {
+		name     string
+		tool     int
+		output   string
+		expected string
+	}{
+		{name: "mkvmerge", tool: 0, output: "mkvmerge v81.0 ('Milliontown') 64-bit\n", expected: "81.0"},
+		{name: "ffmpeg release", tool: 1, output: "ffmpeg version 6.1.1-3ubuntu5 Copyright (c) 2000-2023 the FFmpeg developers\nbuilt with gcc 13\n", expected: "6.1.1"},
+		{name: "ffmpeg tag", tool: 1, output: "ffmpeg version n7.0.2 Copyright (c) 2000-2024\n", expected: "7.0.2"},
+		{name: "ffmpeg git build", tool: 1, output: "ffmpeg version N-113000-g1234abcd Copyright (c) 2000-2024\n", expected: ""},
+		{name: "ffprobe", tool: 2, output: "ffprobe version 4.4.2-0ubuntu0.22.04.1 Copyright\n", expected: "4.4.2"},
+		{name: "Garbage", tool: 2, output: "command not found\n", expected: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := parseVersion([]byte(tc.output), requiredTools[tc.tool].versionRe)
+			if result != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	testCases := []struct {
+		a, b     string
+		expected int
+	}{
+		{"5.0", "5.0", 0},
+		{"5", "5.0", 0},
+		{"4.4.2", "5.0", -1},
+		{"6.1.1", "5.0", 1},
+		{"10.0", "9.0", 1},
+		{"81.0", "9.0", 1},
+	}
+
+	for _, tc := range testCases {
+		if result := compareVersions(tc.a, tc.b); result != tc.expected {
+			t.Errorf("compareVersions(%q, %q): expected %d, got %d", tc.a, tc.b, tc.expected, result)
+		}
+	}
+}
+
+func TestParseEncoders(t *testing.T) {
+	output := `Encoders:
+ V..... = Video
+ A..... = Audio
+ ------
+ V....D libx264              libx264 H.264 / AVC / MPEG-4 AVC / MPEG-4 part 10 (codec h264)
+ A....D aac                  AAC (Advanced Audio Coding)
+ A....D libopus              libopus Opus (codec opus)
+`
+	expected := map[string]bool{"libx264": true, "aac": true, "libopus": true}
+	if result := parseEncoders([]byte(output)); !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+}
+
+func TestCheckOptions(t *testing.T) {
+	caps := Capabilities{Encoders: map[string]bool{"aac": true, "libx265": true}}
+
+	testCases := []struct {
+		name      string
+		modify    func(*Options)
+		expectErr bool
+	}{
+		{name: "Defaults", modify: func(o *Options) {}},
+		{name: "Missing AAC encoder", modify: func(o *Options) { o.AACEncoder = "libfdk_aac" }, expectErr: true},
+		{name: "Missing AAC encoder, no transcoding", modify: func(o *Options) { o.AACEncoder, o.Transcode = "libfdk_aac", nil }},
+		{name: "HEVC re-encoding", modify: func(o *Options) { o.VideoReencode = []string{"vc1"} }},
+		{name: "Missing video encoder", modify: func(o *Options) { o.VideoReencode, o.VideoCodec = []string{"vc1"}, "h264" }, expectErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			tc.modify(&opts)
+			err := caps.CheckOptions(opts)
+			if tc.expectErr != (err != nil) {
+				t.Errorf("expected error=%v, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestToolPath(t *testing.T) {
+	t.Setenv("VIDEOFIX_FFPROBE", "/opt/ffmpeg/bin/ffprobe")
+	t.Setenv("VIDEOFIX_MKVMERGE", "")
+	tools := ToolPaths{FFmpeg: "/opt/ffmpeg7/bin/ffmpeg"}
+
+	testCases := []struct {
+		name     string
+		expected string
+	}{
+		{name: "ffmpeg", expected: "/opt/ffmpeg7/bin/ffmpeg"},
+		{name: "ffprobe", expected: "/opt/ffmpeg/bin/ffprobe"},
+		{name: "mkvmerge", expected: "mkvmerge"},
+	}
+
+	for _, tc := range testCases {
+		if result := tools.path(tc.name); result != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.name, tc.expected, result)
+		}
+	}
+}
+
+func TestProbeToolsNotFound(t *testing.T) {
+	opts := DefaultOptions()
+	opts.ToolPaths = ToolPaths{
+		MKVMerge: "/nonexistent/mkvmerge",
+		FFmpeg:   "/nonexistent/ffmpeg",
+		FFprobe:  "/nonexistent/ffprobe",
+	}
+	caps := ProbeTools(context.Background(), opts)
+	if err := caps.Check(); err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	for _, tool := range caps.Tools {
+		if tool.Err == nil || tool.Path != "" {
+			t.Errorf("%s: expected error and no path, got %q (%v)", tool.Name, tool.Path, tool.Err)
+		}
+	}
+}
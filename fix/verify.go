@@ -0,0 +1,244 @@
+// Output verification.
+//
+// Before replacing the input, the temporary output file is probed again and
+// compared to the plan: the number of tracks of each type, their codecs,
+// languages and default/forced flags, and the duration of the file.
+//
+// The decode mode also decodes all audio and video in the output (failing
+// on any decoding error) and compares the number of packets of each copied
+// track in the input and output files, to catch truncated files.
+
+package fix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Values for Options.Verify.
+const (
+	VerifyOff    = "off"
+	VerifyProbe  = "probe"
+	VerifyDecode = "decode"
+)
+
+// minDurationTolerance is the minimum difference allowed between the
+// durations of the input and output files. Larger files allow 1%.
+const minDurationTolerance = 2 * time.Second
+
+// reencodeCodecIDs maps the target codecs of re-encoded video tracks to the
+// codec names reported by mkvmerge.
+var reencodeCodecIDs = map[string]string{
+	"hevc": "HEVC/H.265/MPEG-H",
+	"h264": "AVC/H.264/MPEG-4p10",
+}
+
+// dispositionDefault returns true if the ffmpeg disposition sets the
+// default flag.
+func dispositionDefault(disposition string) bool {
+	return disposition == "default" || strings.HasPrefix(disposition, "default+")
+}
+
+// dispositionForced returns true if the ffmpeg disposition sets the forced
+// flag.
+func dispositionForced(disposition string) bool {
+	for _, flag := range strings.Split(disposition, "+") {
+		if flag == "forced" {
+			return true
+		}
+	}
+	return false
+}
+
+// expectedCodec returns the codec (as reported by mkvmerge) of the output
+// track for a track plan.
+func expectedCodec(tp TrackPlan) string {
+	switch tp.Action {
+	case ActionTranscode:
+		return aacCodec
+	case ActionReencode:
+		return reencodeCodecIDs[tp.Codec]
+	}
+	return tp.Track.CodecID
+}
+
+// compareOutput compares the tracks and duration of the output file with
+// the plan and returns a list of mismatches.
+func compareOutput(plan Plan, output []Track) []string {
+	var ret []string
+	for _, ttype := range []string{mkvVideoType, mkvAudioType, mkvSubType} {
+		// Attached cover art becomes an MKV attachment, not a track.
+		var expected []TrackPlan
+		for _, tp := range plan.Tracks {
+			if tp.Track.Type == ttype && tp.Action != ActionDrop && tp.Action != ActionAttach {
+				expected = append(expected, tp)
+			}
+		}
+		actual := filterTracks(output, ttype, "", "")
+		if len(actual) != len(expected) {
+			ret = append(ret, fmt.Sprintf("expected %d %s track(s), found %d", len(expected), ttype, len(actual)))
+			continue
+		}
+
+		for i, tp := range expected {
+			out := actual[i]
+			name := fmt.Sprintf("%s track %d (input track %d)", ttype, out.ID, tp.Track.ID)
+			if codec := expectedCodec(tp); out.CodecID != codec {
+				ret = append(ret, fmt.Sprintf("%s: expected codec %s, found %s", name, codec, out.CodecID))
+			}
+			lang := tp.Language
+			if lang == "" {
+				lang = tp.Track.Properties.Language
+			}
+			if lang != "" && out.Properties.Language != lang {
+				ret = append(ret, fmt.Sprintf("%s: expected language %s, found %s", name, lang, out.Properties.Language))
+			}
+			isDefault, isForced := tp.Track.Properties.DefaultTrack, tp.Track.Properties.ForcedTrack
+			if tp.Disposition != "" {
+				isDefault, isForced = dispositionDefault(tp.Disposition), dispositionForced(tp.Disposition)
+			}
+			if out.Properties.DefaultTrack != isDefault {
+				ret = append(ret, fmt.Sprintf("%s: expected default flag %v, found %v", name, isDefault, out.Properties.DefaultTrack))
+			}
+			if out.Properties.ForcedTrack != isForced {
+				ret = append(ret, fmt.Sprintf("%s: expected forced flag %v, found %v", name, isForced, out.Properties.ForcedTrack))
+			}
+		}
+	}
+
+	if len(output) > 0 && plan.Duration > 0 && output[0].Duration > 0 {
+		tolerance := max(minDurationTolerance, plan.Duration/100)
+		diff := output[0].Duration - plan.Duration
+		if diff < -tolerance || diff > tolerance {
+			ret = append(ret, fmt.Sprintf("expected duration %v, found %v", plan.Duration, output[0].Duration))
+		}
+	}
+	return ret
+}
+
+// parsePacketCounts parses the output of ffprobe -count_packets and returns
+// the number of packets in each stream, indexed by stream index.
+func parsePacketCounts(data []byte) (map[int]int64, error) {
+	var probe struct {
+		Streams []struct {
+			Index   int    `json:"index"`
+			Packets string `json:"nb_read_packets"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("error parsing ffprobe JSON output: %w", err)
+	}
+	ret := map[int]int64{}
+	for _, stream := range probe.Streams {
+		n, err := strconv.ParseInt(stream.Packets, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid packet count for stream %d: %q", stream.Index, stream.Packets)
+		}
+		ret[stream.Index] = n
+	}
+	return ret, nil
+}
+
+// countPackets returns the number of packets in each stream of the file.
+// This reads (but does not decode) the whole file.
+func countPackets(ctx context.Context, ffprobe string, file string) (map[int]int64, error) {
+	output, err := runOutput(ctx, ffprobe,
+		"-v", "error",
+		"-count_packets",
+		"-show_entries", "stream=index,nb_read_packets",
+		"-of", "json",
+		file)
+	if err != nil {
+		return nil, err
+	}
+	return parsePacketCounts(output)
+}
+
+// comparePackets compares the packet counts of the tracks copied from the
+// main input file (by stream index) with the ones in the output file (by
+// output position) and returns a list of mismatches.
+func comparePackets(plan Plan, input map[int]int64, output map[int]int64) []string {
+	var ret []string
+	n := 0
+	for _, tp := range plan.Tracks {
+		if tp.Action == ActionDrop || tp.Action == ActionAttach {
+			continue
+		}
+		out := n
+		n++
+		if tp.Action != ActionCopy || tp.Track.Sidecar {
+			continue
+		}
+		if in, ok := input[tp.Track.ID]; ok && output[out] != in {
+			ret = append(ret, fmt.Sprintf("%s track %d (input track %d): expected %d packets, found %d", tp.Track.Type, out, tp.Track.ID, in, output[out]))
+		}
+	}
+	return ret
+}
+
+// decodeOutput decodes all video and audio streams in the file and returns
+// an error if ffmpeg reports any problems.
+func decodeOutput(ctx context.Context, ffmpeg string, file string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffmpeg,
+		"-nostdin",
+		"-v", "error",
+		"-xerror",
+		"-i", file,
+		"-map", "0:v?",
+		"-map", "0:a?",
+		"-f", "null", "-")
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	msg := strings.TrimSpace(stderr.String())
+	if first, _, ok := strings.Cut(msg, "\n"); ok {
+		msg = first + " (...)"
+	}
+	switch {
+	case err != nil && msg != "":
+		return fmt.Errorf("decoding failed: %w: %s", commandError(ctx, err), msg)
+	case err != nil:
+		return fmt.Errorf("decoding failed: %w", commandError(ctx, err))
+	case msg != "":
+		return fmt.Errorf("decoding errors: %s", msg)
+	}
+	return nil
+}
+
+// verifyOutput probes the temporary output file of the plan and returns an
+// error listing all differences from the plan. With VerifyDecode, the output
+// is also fully decoded and the packet counts are compared.
+func verifyOutput(ctx context.Context, plan Plan) error {
+	output, err := Probe(ctx, plan.TempFile, plan.Options)
+	if err != nil {
+		return err
+	}
+	mismatches := compareOutput(plan, output)
+	if plan.Options.Verify == VerifyDecode && len(mismatches) == 0 {
+		ffprobe := plan.Options.path("ffprobe")
+		input, err := countPackets(ctx, ffprobe, plan.Input)
+		if err != nil {
+			return err
+		}
+		output, err := countPackets(ctx, ffprobe, plan.TempFile)
+		if err != nil {
+			return err
+		}
+		mismatches = comparePackets(plan, input, output)
+		if len(mismatches) == 0 {
+			if err := decodeOutput(ctx, plan.Options.path("ffmpeg"), plan.TempFile); err != nil {
+				return err
+			}
+		}
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("%s", strings.Join(mismatches, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package fix
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompareOutput(t *testing.T) {
+	input := []Track{
+		{ID: 0, Type: "video", CodecID: "VC-1", Properties: TrackProperties{Language: "und", DefaultTrack: true, ForcedTrack: true}, File: "movie.mkv", Duration: time.Hour},
+		{ID: 1, Type: "video", CodecID: "MJPEG", Properties: TrackProperties{Language: "und", PixelDimensions: "600x800"}, File: "movie.mkv", Duration: time.Hour},
+		{ID: 2, Type: "audio", CodecID: "E-AC-3", Properties: TrackProperties{Language: "eng"}, File: "movie.mkv", Duration: time.Hour},
+		{ID: 3, Type: "audio", CodecID: "DTS", Properties: TrackProperties{Language: "spa", DefaultTrack: true}, File: "movie.mkv", Duration: time.Hour},
+		{ID: 4, Type: "subtitles", CodecID: "SubRip/SRT", Properties: TrackProperties{Language: "eng", ForcedTrack: true}, File: "movie.mkv", Duration: time.Hour},
+	}
+	opts := DefaultOptions()
+	opts.VideoReencode = []string{"vc1"}
+	opts.Covers = CoversAttach
+	plan, err := NewPlan(context.Background(), input, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	good := []Track{
+		{ID: 0, Type: "video", CodecID: "HEVC/H.265/MPEG-H", Properties: TrackProperties{Language: "und", DefaultTrack: true, ForcedTrack: true}, Duration: time.Hour + time.Second},
+		{ID: 1, Type: "audio", CodecID: "AAC", Properties: TrackProperties{Language: "eng", DefaultTrack: true}},
+		{ID: 2, Type: "audio", CodecID: "DTS", Properties: TrackProperties{Language: "spa"}},
+		{ID: 3, Type: "subtitles", CodecID: "SubRip/SRT", Properties: TrackProperties{Language: "eng", DefaultTrack: true, ForcedTrack: true}},
+	}
+
+	testCases := []struct {
+		name     string
+		modify   func([]Track) []Track
+		expected []string
+	}{
+		{
+			name:   "Matching output",
+			modify: func(tracks []Track) []Track { return tracks },
+		},
+		{
+			name:     "Missing track",
+			modify:   func(tracks []Track) []Track { return append(tracks[:2:2], tracks[3]) },
+			expected: []string{"expected 2 audio track(s), found 1"},
+		},
+		{
+			name: "Wrong codec, language and flags",
+			modify: func(tracks []Track) []Track {
+				tracks[1].CodecID = "E-AC-3"
+				tracks[2].Properties.Language = "und"
+				tracks[2].Properties.DefaultTrack = true
+				tracks[3].Properties.ForcedTrack = false
+				return tracks
+			},
+			expected: []string{
+				"audio track 1 (input track 2): expected codec AAC, found E-AC-3",
+				"audio track 2 (input track 3): expected language spa, found und",
+				"audio track 2 (input track 3): expected default flag false, found true",
+				"subtitles track 3 (input track 4): expected forced flag true, found false",
+			},
+		},
+		{
+			name: "Truncated output",
+			modify: func(tracks []Track) []Track {
+				tracks[0].Duration = 40 * time.Minute
+				return tracks
+			},
+			expected: []string{"expected duration 1h0m0s, found 40m0s"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			output := tc.modify(append([]Track(nil), good...))
+			if result := compareOutput(plan, output); !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("expected:\n%q\ngot:\n%q", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestParsePacketCounts(t *testing.T) {
+	data := `{"programs": [], "streams": [
+		{"index": 0, "nb_read_packets": "86400"},
+		{"index": 1, "nb_read_packets": "112500"},
+		{"index": 2, "nb_read_packets": "1024"}
+	]}`
+	expected := map[int]int64{0: 86400, 1: 112500, 2: 1024}
+	result, err := parsePacketCounts([]byte(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+
+	if _, err := parsePacketCounts([]byte(`{"streams": [{"index": 0, "nb_read_packets": "N/A"}]}`)); err == nil {
+		t.Errorf("expected error for invalid packet count, got nil")
+	}
+}
+
+func TestComparePackets(t *testing.T) {
+	plan := Plan{
+		Tracks: []TrackPlan{
+			{Track: Track{ID: 0, Type: "video"}, Action: ActionCopy},
+			{Track: Track{ID: 1, Type: "video"}, Action: ActionAttach},
+			{Track: Track{ID: 2, Type: "audio"}, Action: ActionDrop},
+			{Track: Track{ID: 3, Type: "audio"}, Action: ActionTranscode},
+			{Track: Track{ID: 4, Type: "audio"}, Action: ActionCopy},
+			{Track: Track{ID: 0, Type: "subtitles", Sidecar: true}, Action: ActionCopy},
+		},
+	}
+	input := map[int]int64{0: 1000, 1: 1, 2: 500, 3: 500, 4: 500}
+
+	testCases := []struct {
+		name     string
+		output   map[int]int64
+		expected []string
+	}{
+		{
+			name:   "Matching counts",
+			output: map[int]int64{0: 1000, 1: 480, 2: 500, 3: 10},
+		},
+		{
+			name:   "Truncated output",
+			output: map[int]int64{0: 700, 1: 480, 2: 350, 3: 10},
+			expected: []string{
+				"video track 0 (input track 0): expected 1000 packets, found 700",
+				"audio track 2 (input track 4): expected 500 packets, found 350",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if result := comparePackets(plan, input, tc.output); !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("expected:\n%q\ngot:\n%q", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestDecodeOutput(t *testing.T) {
+	dir := t.TempDir()
+	testCases := []struct {
+		name      string
+		script    string
+		expectErr bool
+	}{
+		{name: "Clean decode", script: "exit 0\n"},
+		{name: "Decoding errors", script: "echo 'Invalid NAL unit size' >&2\nexit 0\n", expectErr: true},
+		{name: "ffmpeg failure", script: "echo 'Error while decoding stream #0:1' >&2\nexit 1\n", expectErr: true},
+	}
+
+	for i, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ffmpeg := writeScript(t, dir, fmt.Sprintf("ffmpeg%d", i), tc.script)
+			err := decodeOutput(context.Background(), ffmpeg, "movie.mkv")
+			if tc.expectErr != (err != nil) {
+				t.Errorf("expected error=%v, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+// Video track selection and re-encoding.
+//
+// Only the main video track is always kept. Cover art (image tracks) is
+// dropped or turned into an MKV attachment, and extra video tracks (like
+// alternate angles) are optionally dropped. Video tracks are copied unless
+// their codec matches one of the codecs selected for re-encoding.
+
+package fix
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	mkvVideoType = "video"
+
+	// Values for Options.Covers.
+	CoversDrop   = "drop"
+	CoversAttach = "attach"
+)
+
+// coverMimeTypes maps the codecs of image tracks to their mime types.
+var coverMimeTypes = map[string]string{
+	"MJPEG": "image/jpeg",
+	"PNG":   "image/png",
+	"BMP":   "image/bmp",
+	"GIF":   "image/gif",
+}
+
+// videoEncoders maps the target codecs for video re-encoding to the
+// (software) ffmpeg encoders used to produce them.
+var videoEncoders = map[string]string{
+	"hevc": "libx265",
+	"h264": "libx264",
+}
+
+// videoRule describes which video tracks to re-encode and how.
+type videoRule struct {
+	sources []string // Source codec classes (see videoCodecClass).
+	codec   string   // Target codec (a key in videoEncoders).
+	crf     int
+	preset  string
+}
+
+// newVideoRule returns a videoRule from a list of source codec classes and
+// the encoding parameters.
+func newVideoRule(sources []string, codec string, crf int, preset string) (videoRule, error) {
+	if _, ok := videoEncoders[codec]; !ok {
+		return videoRule{}, fmt.Errorf("invalid video codec %q (use 'hevc' or 'h264')", codec)
+	}
+	rule := videoRule{codec: codec, crf: crf, preset: preset}
+	for _, src := range sources {
+		rule.sources = append(rule.sources, strings.ToLower(src))
+	}
+	return rule, nil
+}
+
+// matches returns true if the track must be re-encoded by this rule.
+func (r videoRule) matches(track Track) bool {
+	class := videoCodecClass(track)
+	for _, src := range r.sources {
+		if src == class {
+			return true
+		}
+	}
+	return false
+}
+
+// isHighBitDepthAVC returns true if the H.264 track uses one of the high bit
+// depth profiles (High 10, High 4:2:2 or High 4:4:4), which most hardware
+// decoders don't support. The profile is the second byte of the
+// AVCDecoderConfigurationRecord in the codec private data.
+func isHighBitDepthAVC(track Track) bool {
+	data, err := hex.DecodeString(track.Properties.CodecPrivateData)
+	if err != nil || len(data) < 2 {
+		return false
+	}
+	switch data[1] {
+	case 110, 122, 244:
+		return true
+	}
+	return false
+}
+
+// videoCodecClass returns a short name for the codec of a video track, as
+// used in the list of codecs to re-encode.
+func videoCodecClass(track Track) string {
+	switch track.CodecID {
+	case "MPEG-1/2":
+		return "mpeg2"
+	case "MPEG-4p2":
+		return "mpeg4"
+	case "VC-1":
+		return "vc1"
+	case "AVC/H.264/MPEG-4p10":
+		if isHighBitDepthAVC(track) {
+			return "h264-10bit"
+		}
+		return "h264"
+	case "HEVC/H.265/MPEG-H":
+		return "hevc"
+	}
+	return strings.ToLower(track.CodecID)
+}
+
+// videoEncodeArgs returns the ffmpeg arguments to re-encode the video track
+// at the given output position to codec.
+func videoEncodeArgs(videotrack int, codec string, crf int, preset string) []string {
+	args := []string{
+		fmt.Sprintf("-c:v:%d", videotrack), videoEncoders[codec],
+		fmt.Sprintf("-crf:v:%d", videotrack), strconv.Itoa(crf),
+		fmt.Sprintf("-preset:v:%d", videotrack), preset,
+	}
+	// Most H.264 decoders only support 8-bit 4:2:0.
+	if codec == "h264" {
+		args = append(args, fmt.Sprintf("-pix_fmt:v:%d", videotrack), "yuv420p")
+	}
+	return args
+}
+
+// coverArgs returns the ffmpeg metadata arguments to turn the cover art
+// track at the given output position into an MKV attachment.
+func coverArgs(track Track, videotrack int) []string {
+	mimetype, known := coverMimeTypes[strings.ToUpper(track.CodecID)]
+	if !known {
+		mimetype = "image/jpeg"
+	}
+	ext := strings.TrimPrefix(mimetype, "image/")
+	return []string{
+		fmt.Sprintf("-metadata:s:v:%d", videotrack), "filename=cover." + ext,
+		fmt.Sprintf("-metadata:s:v:%d", videotrack), "mimetype=" + mimetype,
+	}
+}
+
+// isCoverArt returns true if the track is an image (cover art or thumbnail)
+// instead of a real video track.
+func isCoverArt(track Track) bool {
+	if _, ok := coverMimeTypes[strings.ToUpper(track.CodecID)]; ok {
+		return true
+	}
+	return track.Properties.CodecID == "V_MJPEG"
+}
+
+// pixelArea returns the number of pixels in a frame of the track, or zero
+// if the dimensions are unknown.
+func pixelArea(track Track) int {
+	w, h, ok := strings.Cut(track.Properties.PixelDimensions, "x")
+	if !ok {
+		return 0
+	}
+	width, err1 := strconv.Atoi(w)
+	height, err2 := strconv.Atoi(h)
+	if err1 != nil || err2 != nil {
+		return 0
+	}
+	return width * height
+}
+
+// mainVideoTrack returns the main video track in the main input file. The
+// first track with the default flag wins. Without it, the track with the
+// largest frame is used. Returns false if there are no video tracks other
+// than cover art.
+func mainVideoTrack(tracks []Track) (Track, bool) {
+	var candidates []Track
+	for _, track := range filterTracks(tracks, mkvVideoType, "", "") {
+		if !track.Sidecar && !isCoverArt(track) {
+			candidates = append(candidates, track)
+		}
+	}
+	if len(candidates) == 0 {
+		return Track{}, false
+	}
+	for _, track := range candidates {
+		if track.Properties.DefaultTrack {
+			return track, true
+		}
+	}
+	ret := candidates[0]
+	for _, track := range candidates[1:] {
+		if pixelArea(track) > pixelArea(ret) {
+			ret = track
+		}
+	}
+	return ret, true
+}
+
+// videoTrackPlan returns the plan to copy or re-encode a video track. Kind
+// describes the track (main or extra).
+func videoTrackPlan(track Track, rule videoRule, kind string) TrackPlan {
+	if !rule.matches(track) {
+		return TrackPlan{Track: track, Action: ActionCopy, Reason: kind}
+	}
+	return TrackPlan{
+		Track:  track,
+		Action: ActionReencode,
+		Codec:  rule.codec,
+		Reason: fmt.Sprintf("%s, %s --> %s re-encode (crf=%d, preset=%s)",
+			kind, strings.ToUpper(videoCodecClass(track)), strings.ToUpper(rule.codec), rule.crf, rule.preset),
+	}
+}
+
+// planVideo decides what to do with the video tracks. The main video track
+// comes first and is marked as default.
+func planVideo(tracks []Track, covers string, dropExtra bool, rule videoRule) []TrackPlan {
+	var ret []TrackPlan
+
+	mainTrack, ok := mainVideoTrack(tracks)
+	if ok {
+		tp := videoTrackPlan(mainTrack, rule, "main video track")
+		tp.Disposition = "default" + dispositionFlags(mainTrack)
+		ret = append(ret, tp)
+	}
+
+	for _, track := range tracks {
+		if track.Type != mkvVideoType || track.Sidecar || (ok && track.ID == mainTrack.ID) {
+			continue
+		}
+		switch {
+		case isCoverArt(track) && covers == CoversAttach:
+			ret = append(ret, TrackPlan{Track: track, Action: ActionAttach, Disposition: "attached_pic", Reason: "cover art"})
+		case isCoverArt(track):
+			ret = append(ret, TrackPlan{Track: track, Action: ActionDrop, Reason: "cover art"})
+		case dropExtra:
+			ret = append(ret, TrackPlan{Track: track, Action: ActionDrop, Reason: "extra video track (--drop-extra-video)"})
+		default:
+			tp := videoTrackPlan(track, rule, "extra video track")
+			tp.Disposition = "-default" + dispositionFlags(track)
+			ret = append(ret, tp)
+		}
+	}
+	return ret
+}
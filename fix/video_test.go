@@ -0,0 +1,190 @@
+package fix
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMainVideoTrack(t *testing.T) {
+	testCases := []struct {
+		name       string
+		tracks     []Track
+		expectedID int
+		expectedOK bool
+	}{
+		{
+			name: "Default flag wins",
+			tracks: []Track{
+				{ID: 0, Type: "video", CodecID: "AVC/H.264/MPEG-4p10", Properties: TrackProperties{PixelDimensions: "1920x1080"}},
+				{ID: 1, Type: "video", CodecID: "AVC/H.264/MPEG-4p10", Properties: TrackProperties{PixelDimensions: "1280x720", DefaultTrack: true}},
+			},
+			expectedID: 1,
+			expectedOK: true,
+		},
+		{
+			name: "Largest frame without default flag",
+			tracks: []Track{
+				{ID: 0, Type: "video", CodecID: "AVC/H.264/MPEG-4p10", Properties: TrackProperties{PixelDimensions: "720x480"}},
+				{ID: 1, Type: "video", CodecID: "HEVC/H.265/MPEG-H", Properties: TrackProperties{PixelDimensions: "3840x2160"}},
+			},
+			expectedID: 1,
+			expectedOK: true,
+		},
+		{
+			name: "Cover art is never the main track",
+			tracks: []Track{
+				{ID: 0, Type: "video", CodecID: "MJPEG", Properties: TrackProperties{PixelDimensions: "3000x3000", DefaultTrack: true}},
+				{ID: 1, Type: "video", CodecID: "AVC/H.264/MPEG-4p10", Properties: TrackProperties{PixelDimensions: "1920x1080"}},
+			},
+			expectedID: 1,
+			expectedOK: true,
+		},
+		{
+			name: "Only cover art",
+			tracks: []Track{
+				{ID: 0, Type: "video", CodecID: "PNG"},
+				{ID: 1, Type: "audio", CodecID: "AAC"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, ok := mainVideoTrack(tc.tracks)
+			if ok != tc.expectedOK {
+				t.Fatalf("expected ok=%v, got %v", tc.expectedOK, ok)
+			}
+			if ok && result.ID != tc.expectedID {
+				t.Errorf("expected track %d, got %d", tc.expectedID, result.ID)
+			}
+		})
+	}
+}
+
+func TestPlanVideo(t *testing.T) {
+	tracks := []Track{
+		{ID: 0, Type: "video", CodecID: "MJPEG"},
+		{ID: 1, Type: "video", CodecID: "AVC/H.264/MPEG-4p10", Properties: TrackProperties{PixelDimensions: "1920x1080"}},
+		{ID: 2, Type: "video", CodecID: "AVC/H.264/MPEG-4p10", Properties: TrackProperties{PixelDimensions: "720x480"}},
+		{ID: 3, Type: "audio", CodecID: "AAC"},
+	}
+
+	testCases := []struct {
+		name      string
+		covers    string
+		dropExtra bool
+		expected  []string
+	}{
+		{
+			name:     "Drop covers, keep extra tracks",
+			covers:   "drop",
+			expected: []string{"video 1: copy", "video 0: drop", "video 2: copy"},
+		},
+		{
+			name:      "Attach covers, drop extra tracks",
+			covers:    "attach",
+			dropExtra: true,
+			expected:  []string{"video 1: copy", "video 0: attach", "video 2: drop"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := decisions(planVideo(tracks, tc.covers, tc.dropExtra, videoRule{}))
+			if !reflect.DeepEqual(result, tc.expected) {
+				t.Errorf("expected:\n%v\ngot:\n%v", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestPlanVideoDisposition(t *testing.T) {
+	tracks := []Track{
+		{ID: 0, Type: "video", CodecID: "HEVC/H.265/MPEG-H", Properties: TrackProperties{DefaultTrack: true, ForcedTrack: true}},
+		{ID: 1, Type: "video", CodecID: "HEVC/H.265/MPEG-H", Properties: TrackProperties{FlagCommentary: true}},
+	}
+	var result []string
+	for _, tp := range planVideo(tracks, CoversDrop, false, videoRule{}) {
+		result = append(result, tp.Disposition)
+	}
+	if expected := []string{"default+forced", "-default+comment"}; !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected:\n%v\ngot:\n%v", expected, result)
+	}
+}
+
+func TestPlanCommandVideo(t *testing.T) {
+	plan := Plan{
+		Input:    "input.mkv",
+		TempFile: "output.mkv",
+		Options:  DefaultOptions(),
+		Tracks: []TrackPlan{
+			{Track: Track{ID: 1, Type: "video", CodecID: "AVC/H.264/MPEG-4p10"}, Action: ActionCopy, Disposition: "default"},
+			{Track: Track{ID: 0, Type: "video", CodecID: "MJPEG"}, Action: ActionAttach, Disposition: "attached_pic"},
+			{Track: Track{ID: 2, Type: "video", CodecID: "AVC/H.264/MPEG-4p10"}, Action: ActionDrop},
+		},
+	}
+	expected := []string{
+		"ffmpeg", "-loglevel", "error", "-nostats", "-progress", "pipe:1", "-i", "input.mkv",
+		"-map_chapters", "0", "-map_metadata", "0",
+		"-map", "0:1", "-c:v:0", "copy", "-disposition:v:0", "default",
+		"-map", "0:0", "-c:v:1", "copy", "-disposition:v:1", "attached_pic",
+		"-metadata:s:v:1", "filename=cover.jpeg", "-metadata:s:v:1", "mimetype=image/jpeg",
+		"-max_interleave_delta", "0", "-y", "-f", "matroska", "output.mkv",
+	}
+
+	result := plan.Command()
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected:\n%v\ngot:\n%v", expected, result)
+	}
+}
+
+func TestVideoCodecClass(t *testing.T) {
+	testCases := []struct {
+		name     string
+		track    Track
+		expected string
+	}{
+		{"MPEG-2", Track{CodecID: "MPEG-1/2"}, "mpeg2"},
+		{"VC-1", Track{CodecID: "VC-1"}, "vc1"},
+		{"8-bit H.264 (High)", Track{CodecID: "AVC/H.264/MPEG-4p10", Properties: TrackProperties{CodecPrivateData: "0164002affe1"}}, "h264"},
+		{"10-bit H.264 (High 10)", Track{CodecID: "AVC/H.264/MPEG-4p10", Properties: TrackProperties{CodecPrivateData: "016e0033ffe1"}}, "h264-10bit"},
+		{"H.264 without private data", Track{CodecID: "AVC/H.264/MPEG-4p10"}, "h264"},
+		{"Unknown codec", Track{CodecID: "AV1"}, "av1"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if result := videoCodecClass(tc.track); result != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, result)
+			}
+		})
+	}
+}
+
+func TestPlanVideoReencode(t *testing.T) {
+	tracks := []Track{
+		{ID: 0, Type: "video", CodecID: "VC-1"},
+		{ID: 1, Type: "video", CodecID: "AVC/H.264/MPEG-4p10"},
+	}
+
+	rule, err := newVideoRule([]string{"vc1", "MPEG2"}, "h264", 20, "slow")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	plans := planVideo(tracks, "drop", false, rule)
+	if result, expected := decisions(plans), []string{"video 0: reencode", "video 1: copy"}; !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected:\n%v\ngot:\n%v", expected, result)
+	}
+	if plans[0].Codec != "h264" {
+		t.Errorf("expected codec h264, got %q", plans[0].Codec)
+	}
+
+	expected := []string{"-c:v:0", "libx264", "-crf:v:0", "20", "-preset:v:0", "slow", "-pix_fmt:v:0", "yuv420p"}
+	if result := videoEncodeArgs(0, "h264", 20, "slow"); !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected:\n%v\ngot:\n%v", expected, result)
+	}
+
+	if _, err := newVideoRule([]string{"vc1"}, "vp9", 20, "slow"); err == nil {
+		t.Errorf("expected error for invalid target codec, got none")
+	}
+}
@@ -1,3 +1,10 @@
 module github.com/marcopaganini/videofix
 
 go 1.24.2
+
+require (
+	github.com/fsnotify/fsnotify v1.10.1
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require golang.org/x/sys v0.13.0 // indirect
@@ -0,0 +1,108 @@
+// Pre and post hooks.
+//
+// The pre-hook runs after planning a file and before running ffmpeg. A
+// non-zero exit skips the file. The post-hook runs after processing the
+// file, whether it was fixed or failed. Both get the details of the file in
+// environment variables (see fix.HookEnv) and a JSON report with the plan
+// and, for the post-hook, the outcome. Hooks don't run in dry runs, or for
+// files skipped because they are unchanged.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+
+	"github.com/marcopaganini/videofix/fix"
+)
+
+// hookPending is the status passed to the pre-hook.
+const hookPending = "pending"
+
+// errSkipped is returned when the pre-hook skips a file.
+var errSkipped = errors.New("skipped by the pre-hook")
+
+// hookReport is the JSON report passed to hooks.
+type hookReport struct {
+	Input   string    `json:"input"`
+	Output  string    `json:"output,omitempty"`
+	Status  string    `json:"status"`
+	Error   string    `json:"error,omitempty"`
+	Changes []string  `json:"changes,omitempty"`
+	Plan    *fix.Plan `json:"plan,omitempty"`
+}
+
+// runHook writes the report to a temporary file and runs the hook command.
+func runHook(ctx context.Context, command string, opts fix.Options, hook string, report hookReport) error {
+	f, err := os.CreateTemp("", "videofix-report-*.json")
+	if err != nil {
+		return fmt.Errorf("unable to write hook report: %w", err)
+	}
+	defer os.Remove(f.Name())
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(report)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write hook report: %w", err)
+	}
+
+	env := fix.HookEnv{
+		Hook:   hook,
+		Input:  report.Input,
+		Output: report.Output,
+		Status: report.Status,
+		Error:  report.Error,
+		Report: f.Name(),
+	}
+	return fix.RunHook(ctx, command, opts.HookTimeout, env)
+}
+
+// newReport returns the hook report for a plan. The plan is blank if
+// planning failed.
+func newReport(file string, plan fix.Plan, status string) hookReport {
+	report := hookReport{Input: file, Status: status}
+	if plan.Input != "" {
+		report.Output = fixedFile(plan)
+		report.Changes = plan.Changes()
+		report.Plan = &plan
+	}
+	return report
+}
+
+// preHook runs the pre-hook for a plan. It returns an error wrapping
+// errSkipped if the hook exits with a non-zero status.
+func preHook(ctx context.Context, plan fix.Plan) error {
+	if plan.Options.PreHook == "" || plan.Options.DryRun {
+		return nil
+	}
+	err := runHook(ctx, plan.Options.PreHook, plan.Options, fix.PreHook, newReport(plan.Input, plan, hookPending))
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return fmt.Errorf("%w (exit status %d)", errSkipped, exitErr.ExitCode())
+	}
+	return err
+}
+
+// postHook runs the post-hook after processing a file, with the outcome
+// in fixErr. Errors are logged. The hook also runs (with its own timeout)
+// when processing was interrupted.
+func postHook(ctx context.Context, file string, opts fix.Options, plan fix.Plan, fixErr error) {
+	if opts.PostHook == "" || opts.DryRun {
+		return
+	}
+	report := newReport(file, plan, outcomeFixed)
+	if fixErr != nil {
+		report.Status, report.Error = outcomeFailed, fixErr.Error()
+	}
+	if err := runHook(context.WithoutCancel(ctx), opts.PostHook, opts, fix.PostHook, report); err != nil {
+		log.Printf("%s: WARNING: %v", file, err)
+	}
+}
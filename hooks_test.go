@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/marcopaganini/videofix/fix"
+)
+
+func TestPreHook(t *testing.T) {
+	testCases := []struct {
+		name      string
+		hook      string
+		dryRun    bool
+		expectErr error
+	}{
+		{name: "No hook"},
+		{name: "Proceed", hook: "test \"$VIDEOFIX_STATUS\" = pending"},
+		{name: "Skip", hook: "exit 1", expectErr: errSkipped},
+		{name: "Dry run", hook: "exit 1", dryRun: true},
+		{name: "Timeout", hook: "sleep 10", expectErr: fix.ErrTimeout},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			plan := fix.Plan{Input: "movie.mkv", Output: "movie.mkv"}
+			plan.Options = fix.DefaultOptions()
+			plan.Options.PreHook, plan.Options.DryRun = tc.hook, tc.dryRun
+			plan.Options.HookTimeout = 200 * time.Millisecond
+			err := preHook(context.Background(), plan)
+			if tc.expectErr == nil && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tc.expectErr != nil && !errors.Is(err, tc.expectErr) {
+				t.Errorf("expected %v, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestPostHook(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "report.json")
+	opts := fix.DefaultOptions()
+	opts.PostHook = `cp "$VIDEOFIX_REPORT" ` + out
+
+	plan := fix.Plan{Input: "movie.mp4", Output: "movie.mp4", Options: opts}
+	postHook(context.Background(), "movie.mp4", opts, plan, errors.New("ffmpeg failed"))
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report hookReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Input != "movie.mp4" || report.Output != "movie.mkv" || report.Status != outcomeFailed || report.Error != "ffmpeg failed" || report.Plan == nil {
+		t.Errorf("unexpected report: %+v", report)
+	}
+}
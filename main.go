@@ -1,159 +1,37 @@
 // Fix common problems in MKV files:
 //
 // - Convert EAC3 audio to AAC to avoid issues with players.
+// - Keep only the main video track, dropping cover art.
+// - Optionally re-encode video tracks in codecs unsupported by players.
 // - If the file has equivalent EAC3/AAC tracks, remove the EAC3 version.
 // - Set all "eng" tracks to be the default tracks.
-// - All other tracks and metadata is copied from the original file.
+// - All other tracks and metadata is copied from the original file, unless
+//   global tag and chapter editing is requested.
+//
+// This is a thin command line wrapper around the fix package. See commands.go
+// for the list of subcommands.
 //
 // (C) Jul/2025 by Marco Paganini <paganini@paganini.net>
 
 package main
 
 import (
-	"encoding/json"
-	"flag"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strings"
-)
-
-const (
-	outputSuffix = "_with_aac"
-	eac3Codec    = "E-AC-3"
-	aacCodec     = "AAC"
-	aacBitrate   = "256k"
-	mkvAudioType = "audio"
-	mkvSubType   = "subtitles"
-)
+	"syscall"
+	"text/tabwriter"
 
-var (
-	optLang   = flag.String("lang", "eng", "Default language for audio and subtitle tracks")
-	optPrune  = flag.Bool("prune", false, "Prune tracks not in the default language or 'und'")
-	optDir    = flag.String("dir", "", "Directory mode. Use largest MKV/MP4 file in directory as the input")
-	optFile   = flag.String("input", "", "Input filename")
-	outputDir = flag.String("output", "", "Output directory.")
+	"github.com/marcopaganini/videofix/fix"
 )
 
-// trackInfo holds information about a track from mkvmerge.
-type trackInfo struct {
-	ID         int    `json:"id"`
-	Type       string `json:"type"`
-	CodecID    string `json:"codec"`
-	Properties struct {
-		Language string `json:"language"`
-	} `json:"properties"`
-}
-
-// mkvInfo holds the top-level JSON structure from mkvmerge.
-type mkvInfo struct {
-	Tracks []trackInfo `json:"tracks"`
-}
-
-// checkRequirements returns an error if any of the required programs
-// are not installed in the system.
-func checkRequirements() error {
-	if _, err := exec.LookPath("mkvmerge"); err != nil {
-		return fmt.Errorf("mkvmerge not found. Please install the mkvtoolnix package")
-	}
-	if _, err := exec.LookPath("ffmpeg"); err != nil {
-		return fmt.Errorf("ffmpeg not found. Please install the ffmpeg package")
-	}
-	return nil
-}
-
-// readTracksFunc returns a map of all tracks in the input file using
-// mkvmerge --identify.
-func readTracksFunc(inputFile string) ([]trackInfo, error) {
-	// Get track information using mkvmerge.
-	cmd := exec.Command("mkvmerge", "--identify", "-F", "json", inputFile)
-	output, err := cmd.Output()
-	if err != nil {
-		return []trackInfo{}, fmt.Errorf("error running mkvmerge: %w", err)
-	}
-
-	var info mkvInfo
-	if err := json.Unmarshal(output, &info); err != nil {
-		return []trackInfo{}, fmt.Errorf("error parsing mkvmerge JSON output: %w", err)
-	}
-
-	tracks := []trackInfo{}
-	for _, track := range info.Tracks {
-		t := trackInfo{
-			ID:         track.ID,
-			Type:       track.Type,
-			CodecID:    track.CodecID,
-			Properties: track.Properties,
-		}
-		tracks = append(tracks, t)
-	}
-
-	return tracks, nil
-}
-
-// filterTracks returns a list of tracks filtered by type, codec and language. If any of the
-// parameters is blank, ignore it during comparison.
-func filterTracks(tracks []trackInfo, ttype string, codec string, lang string) []trackInfo {
-	var ret []trackInfo
-	for _, track := range tracks {
-		if ttype != "" && track.Type != ttype {
-			continue
-		}
-		if codec != "" && track.CodecID != codec {
-			continue
-		}
-		if lang != "" && track.Properties.Language != lang {
-			continue
-		}
-		ret = append(ret, track)
-	}
-	return ret
-}
-
-// pruneOK returns checks if pruning would remove all tracks of a given type
-// and language from the output (E.g, resulting in a file with no audio
-// tracks).  Returns nil or an error.
-func pruneOK(tracks []trackInfo, lang string) error {
-	// Filter all output tracks using the default language.
-	var filteredTracks []trackInfo
-	for _, t := range tracks {
-		if t.Properties.Language == lang || t.Properties.Language == "und" {
-			filteredTracks = append(filteredTracks, t)
-		}
-	}
-	// Make sure we will still have at least one of each input track type and
-	// language in the output.
-	inputTrackTypes := make(map[string]bool)
-	for _, t := range tracks {
-		inputTrackTypes[t.Type] = true
-	}
-	outputTrackTypes := make(map[string]bool)
-	for _, t := range filteredTracks {
-		outputTrackTypes[t.Type] = true
-	}
-	for trackType := range inputTrackTypes {
-		if !outputTrackTypes[trackType] {
-			return fmt.Errorf("pruning would remove all %s tracks from the output", trackType)
-		}
-	}
-	return nil
-}
-
-func langAndDisposition(track trackInfo) (string, string) {
-	lang := "und"
-	disposition := "-default"
-
-	if track.Properties.Language != "" {
-		lang = track.Properties.Language
-	}
-	if lang == *optLang {
-		disposition = "default"
-	}
-	return lang, disposition
-}
-
 // printHeader prints a header using the passed string. The string is broken down by
 // newlines and a separator is printed before the first line and after the first line
 // to match the longest line in the string.
@@ -172,237 +50,126 @@ func printHeader(header string) {
 	fmt.Println(strings.Repeat("=", maxlen))
 }
 
-// transcoderCmd creates an ffmpeg command to transcode EAC3 tracks to AAC
-// and copy the remaining data.
-func transcoderCmd(inputFile string, outputFile string, tracks []trackInfo, doPrune bool, optlang string) []string {
-	// Create the ffmpeg command line.
-	args := []string{
-		"ffmpeg",
-		"-loglevel", "error",
-		"-stats",
-		"-i", inputFile,
-		"-c:v", "copy", // Default codec for video = copy.
-		"-map", "0:v", // Copy all video tracks first.
-		"-map_chapters", "0", // Copy all chapters
-		"-map_metadata", "0", // Copy all metadata
-	}
-
-	// Add AAC conversion for each EAC3 track.
-	// Copy non-EAC3 audio tracks directly.
-	// Copy subtitle tracks directly.
-	// Set the default flag on "eng" tracks.
-
-	// IMPORTANT: The -map command uses the INPUT track number while the
-	// -c:a:TRACK command uses the relative OUTPUT track number.
-	audiotrack := 0
-	subtrack := 0
-
-	// Run first for audio tracks, then subtitle tracks so we maintain the
-	// A/V/S order in the output file.
-
-	printHeader("Processing AUDIO tracks")
-
-	for _, track := range tracks {
-		trackAction := ""
-
-		if track.Type != mkvAudioType {
-			continue
-		}
-
-		lang, disposition := langAndDisposition(track)
+// printPlan prints the input tracks and the decisions in the plan.
+func printPlan(plan fix.Plan) {
+	printHeader(fmt.Sprintf("File: %s\nList of input tracks", plan.Input))
 
-		// If pruning is enabled, skip tracks that are not in the default language or "und".
-		if doPrune && lang != optlang && lang != "und" {
-			log.Printf("  %d: codec=%s lang=%s: Skipping due to --prune flag.", track.ID, track.CodecID, track.Properties.Language)
-			continue
+	// List the main input tracks by ID, followed by the sidecar tracks.
+	inputs := slices.Clone(plan.Tracks)
+	sort.SliceStable(inputs, func(i, j int) bool {
+		a, b := inputs[i].Track, inputs[j].Track
+		return !a.Sidecar && (b.Sidecar || a.ID < b.ID)
+	})
+	for _, tp := range inputs {
+		track := tp.Track
+		switch {
+		case track.Sidecar:
+			log.Printf("  - ID: %d (%s), Codec: %s, Language: %s, File: %s", track.ID, track.Type, track.CodecID, track.Properties.Language, track.File)
+		case track.HDR.String() != "":
+			log.Printf("  - ID: %d (%s), Codec: %s, Language: %s, HDR: %s", track.ID, track.Type, track.CodecID, track.Properties.Language, track.HDR)
+		default:
+			log.Printf("  - ID: %d (%s), Codec: %s, Language: %s", track.ID, track.Type, track.CodecID, track.Properties.Language)
 		}
+	}
 
-		trackData := fmt.Sprintf("%d: codec=%s lang=%s", track.ID, track.CodecID, lang)
-
-		// Transcode or copy.
-		if track.CodecID == eac3Codec {
-			// If we have an equivalent AAC track with the same language and
-			// language is not "und", ignore that the EAC3 track.
-			if lang != "und" {
-				equivalent := filterTracks(tracks, mkvAudioType, aacCodec, lang)
-				if len(equivalent) > 0 {
-					trackAction = fmt.Sprintf("found %d AAC equivalent audio track(s). Skipping.", len(equivalent))
-					log.Println("  " + trackData + ": " + trackAction)
-					continue
-				}
-			}
-			trackAction = "selected for EAC3 --> AAC conversion"
-			args = append(args,
-				fmt.Sprintf("-c:a:%d", audiotrack), "aac",
-				fmt.Sprintf("-b:a:%d", audiotrack), aacBitrate,
-				fmt.Sprintf("-metadata:s:a:%d", audiotrack), fmt.Sprintf("title=AAC Audio (%s)", lang))
-		} else {
-			trackAction = "selected for COPY."
-			args = append(args, fmt.Sprintf("-c:a:%d", audiotrack), "copy")
+	if len(plan.Warnings) > 0 {
+		printHeader("HDR metadata warnings")
+		for _, warning := range plan.Warnings {
+			log.Println("  " + warning)
 		}
-		args = append(args,
-			"-map", fmt.Sprintf("0:%d", track.ID),
-			fmt.Sprintf("-disposition:a:%d", audiotrack), disposition)
-
-		log.Println("  " + trackData + ": " + trackAction)
-		audiotrack++
 	}
 
-	printHeader("Processing SUBTITLES tracks")
-
-	for _, track := range tracks {
-		trackAction := ""
-
-		if track.Type != mkvSubType {
-			continue
+	opts := plan.Options
+	if opts.StripTags || opts.TitleFromFilename || opts.Chapters != fix.ChaptersKeep {
+		printHeader("Metadata changes")
+		for _, line := range plan.Metadata.Diff() {
+			log.Println("  " + line)
 		}
-
-		lang, disposition := langAndDisposition(track)
-
-		// If pruning is enabled, skip tracks that are not in the default language or "und".
-		if doPrune && optlang != lang && lang != "und" {
-			log.Printf("  %d: codec=%s lang=%s: Skipping due to --prune flag.", track.ID, track.CodecID, lang)
-			continue
+		if len(plan.Metadata.Diff()) == 0 {
+			log.Println("  No changes.")
 		}
-
-		trackData := fmt.Sprintf("%d: codec=%s lang=%s", track.ID, track.CodecID, track.Properties.Language)
-
-		// Map track for output, copy and set disposition.
-		args = append(args,
-			"-map", fmt.Sprintf("0:%d", track.ID),
-			fmt.Sprintf("-c:s:%d", subtrack), "copy",
-			fmt.Sprintf("-disposition:s:%d", subtrack), disposition)
-
-		trackAction = "selected for COPY."
-		log.Println("  " + trackData + ": " + trackAction)
-		subtrack++
-	}
-
-	// Final arguments.
-	args = append(args,
-		"-max_interleave_delta", "0",
-		"-y",
-		"-f", "matroska",
-		outputFile)
-
-	return args
-}
-
-// transcodeEAC3 converts EAC3 audio to AAC audio in the input file.
-func transcodeEAC3(infile string, readTracksFunc func(string) ([]trackInfo, error)) error {
-	// Check if the input file exists
-	if _, err := os.Stat(infile); os.IsNotExist(err) {
-		return fmt.Errorf("file not found: %s", infile)
-	}
-
-	// Generate the output filename
-	dirname := filepath.Dir(infile)
-	filename := filepath.Base(infile)
-	extension := strings.ToLower(filepath.Ext(filename))
-	filenameNoExt := strings.TrimSuffix(filename, filepath.Ext(filename))
-
-	if extension != ".mkv" && extension != ".mp4" {
-		return fmt.Errorf("not an MKV or MP4 file: %s", infile)
 	}
 
-	// Use outputDir if specified
-	if *outputDir != "" {
-		dirname = *outputDir
-		if err := os.MkdirAll(dirname, 0775); err != nil {
-			return fmt.Errorf("unable to create output directory: %s", dirname)
+	for _, section := range []struct{ title, ttype string }{
+		{"Processing VIDEO tracks", "video"},
+		{"Processing AUDIO tracks", "audio"},
+		{"Processing SUBTITLES tracks", "subtitles"},
+	} {
+		printHeader(section.title)
+		for _, tp := range plan.Tracks {
+			if tp.Track.Type != section.ttype {
+				continue
+			}
+			trackData := fmt.Sprintf("%d: codec=%s", tp.Track.ID, tp.Track.CodecID)
+			if tp.Language != "" {
+				trackData += " lang=" + tp.Language
+			}
+			if hdr := tp.Track.HDR.String(); hdr != "" {
+				trackData += " hdr=" + hdr
+			}
+			trackAction := strings.ToUpper(tp.Action)
+			if tp.Reason != "" {
+				trackAction += " (" + tp.Reason + ")"
+			}
+			if tp.Title != "" {
+				trackAction += fmt.Sprintf(". Title: %q", tp.Title)
+			}
+			log.Println("  " + trackData + ": " + trackAction + ".")
 		}
 	}
-	outputFile := filepath.Join(dirname, fmt.Sprintf("%s%s%s.TMP", filenameNoExt, outputSuffix, extension))
-
-	// Do not proceed if our temp file already exists.  This may mean another
-	// instance running or some other condition that needs to be investigated.
-	if _, err := os.Stat(outputFile); err == nil {
-		return fmt.Errorf("output file '%s' already exists. Skipping", outputFile)
-	}
+}
 
-	tracks, err := readTracksFunc(infile)
+// makePlan probes a file (and its sidecar files, if requested) and returns
+// the plan to fix it.
+func makePlan(ctx context.Context, infile string, opts fix.Options) (fix.Plan, error) {
+	tracks, err := fix.Probe(ctx, infile, opts)
 	if err != nil {
-		return err
+		return fix.Plan{}, err
 	}
-
-	printHeader(fmt.Sprintf("File: %s\nList of input tracks", infile))
-
-	for _, track := range tracks {
-		log.Printf("  - ID: %d (%s), Codec: %s, Language: %s", track.ID, track.Type, track.CodecID, track.Properties.Language)
-	}
-
-	// If pruning is enabled, filter tracks and check if any track type is completely removed.
-	tracksToProcess := tracks
-	if *optPrune {
-		err = pruneOK(tracks, *optLang)
+	if opts.Sidecars {
+		sidecars, err := fix.ProbeSidecars(ctx, infile, opts)
 		if err != nil {
-			return err
+			return fix.Plan{}, err
 		}
+		tracks = append(tracks, sidecars...)
 	}
+	return fix.NewPlan(ctx, tracks, opts)
+}
 
-	tcmd := transcoderCmd(infile, outputFile, tracksToProcess, *optPrune, *optLang)
-	printHeader("Executing command")
-	log.Println("'" + strings.Join(tcmd, "' '") + "'")
-
-	// Execute the ffmpeg command, send all output to stderr.
-	cmd := exec.Command(tcmd[0], tcmd[1:]...)
-	cmd.Stdout = os.Stderr
-	cmd.Stderr = os.Stderr
-
-	if err := cmd.Run(); err != nil {
-		_ = os.Remove(outputFile)
-		return fmt.Errorf("ffmpeg conversion failed for %s: %v", infile, err)
+// runPlan shows the ffmpeg command and executes the plan, calling fn with
+// the progress.
+func runPlan(ctx context.Context, plan fix.Plan, fn fix.ProgressFunc) error {
+	if err := plan.CheckInputs(); err != nil {
+		return err
 	}
-
-	// Rename the output file back to the original name in the output directory
-	newOutputFile := filepath.Join(dirname, fmt.Sprintf("%s%s", filenameNoExt, extension))
-	if err := os.Rename(outputFile, newOutputFile); err != nil {
-		return fmt.Errorf("failed to move '%s' to '%s': %v", outputFile, newOutputFile, err)
+	header := "Executing command"
+	if plan.Options.DryRun {
+		header = "Command (dry run, not executed)"
 	}
-	// If the input file is a .mp4 file. In this case, since we crated a mkv
-	// file during transcoding, rename it to .mkv
-	if extension == ".mp4" {
-		if err := os.Rename(infile, strings.TrimSuffix(infile, ".mp4")+".mkv"); err != nil {
-			return fmt.Errorf("failed to move '%s' to '%s': %v", outputFile, infile, err)
+	printHeader(header)
+	log.Println("'" + strings.Join(plan.Command(), "' '") + "'")
+	if !plan.Options.DryRun && plan.Options.SpacePolicy == fix.SpacePolicyWait {
+		if err := plan.CheckSpace(); errors.Is(err, fix.ErrNoSpace) {
+			log.Printf("  Waiting for free space: %v", err)
 		}
 	}
-	return nil
-}
-
-// findVideoFile scans the passed directory and returns the largest file with a
-// .mkv or .mp4 extension.  Files with .mkv extension are preferred. If no
-// files with these extensions exist, an error is returned.
-func findVideoFile(dir string) (string, error) {
-	var largestFile string
-	var largestSize int64
-
-	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if info.IsDir() {
-			return nil
-		}
-		if strings.HasSuffix(info.Name(), ".mkv") || strings.HasSuffix(info.Name(), ".mp4") {
-			if info.Size() > largestSize {
-				largestFile = path
-				largestSize = info.Size()
-			}
-		}
-		return nil
-	})
-	if err != nil {
-		return "", err
-	}
-	return largestFile, nil
+	return fix.ExecuteProgress(ctx, plan, fn)
 }
 
 // usage prints a customized usage message.
 func usage() {
 	progname := filepath.Base(os.Args[0])
-	fmt.Fprintf(os.Stderr, "Usage: %s [options] [<input_file.mkv> | --dir <directory>]\n\n", progname)
-	fmt.Fprintln(os.Stderr, "Options:")
-	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "Usage: %s <command> [options] <file>...\n\n", progname)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s %s\t%s\n", cmd.name, cmd.args, cmd.help)
+	}
+	w.Flush()
+	fmt.Fprintf(os.Stderr, "\nThe command defaults to \"fix\". Use \"%s <command> --help\" for the options\n", progname)
+	fmt.Fprintf(os.Stderr, "of each command.\n\n")
+	fmt.Fprintf(os.Stderr, "Settings are also read from %s and from %s files\n", userConfigFile(), dirConfigName)
+	fmt.Fprintf(os.Stderr, "in the directory of the input file and its parents.\n")
 }
 
 func main() {
@@ -411,39 +178,33 @@ func main() {
 	// No date & time on logs.
 	log.SetFlags(0)
 
-	flag.Usage = usage
-	flag.Parse()
-
-	if (*optDir == "" && *optFile == "") || (*optDir != "" && *optFile != "") {
-		flag.Usage()
-		os.Exit(1)
-	}
-
-	if *optPrune && *optLang == "" {
-		log.Fatalf("When --prune is specified, --lang becomes mandatory.")
-	}
-	if *optLang == "" {
-		log.Printf("No language specified. All tracks will be copied.")
+	// Without a known command, run "fix" for compatibility with older
+	// versions (videofix [options] <file>).
+	cmd, args := findCommand("fix"), os.Args[1:]
+	if len(args) > 0 {
+		switch {
+		case args[0] == "help" || args[0] == "-h" || args[0] == "--help":
+			usage()
+			os.Exit(0)
+		case findCommand(args[0]) != nil:
+			cmd, args = findCommand(args[0]), args[1:]
+		}
 	}
-
-	if err := checkRequirements(); err != nil {
-		log.Fatalf("Error: %v", err)
+	if len(os.Args) == 1 {
+		usage()
+		os.Exit(1)
 	}
 
-	var err error
-
-	movieFile := *optFile
-	if *optDir != "" {
-		movieFile, err = findVideoFile(*optDir)
-		if err != nil {
-			log.Fatalf("%s: ERROR: trying to find movie in directory %s: %v\n", progname, *optDir, err)
-		}
-		log.Printf("Using file: %s\n", movieFile)
-	}
+	// Cancel the running command on SIGINT or SIGTERM. External programs
+	// are killed and partial outputs removed. A second signal exits at once.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
 
-	if err := transcodeEAC3(movieFile, readTracksFunc); err != nil {
-		log.Fatalf("%s: ERROR: %s:%v\n", progname, movieFile, err)
+	if err := cmd.run(ctx, args); err != nil {
+		log.Fatalf("%s: ERROR: %v\n", progname, err)
 	}
-	log.Printf("%s: Operation successful.\n", movieFile)
 	os.Exit(0)
 }
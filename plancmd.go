@@ -0,0 +1,176 @@
+// Saved plans.
+//
+// "videofix plan" writes the plans for a list of files to a JSON file, which
+// can be reviewed and edited. "videofix apply" executes exactly those plans
+// later, refusing the ones whose input files changed in the meantime.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/marcopaganini/videofix/fix"
+)
+
+// fileFlags binds opts to a new flag set and sets it to the defaults,
+// overridden by the configuration files applying to the file, overridden by
+// the flags set in the command line (fs). It returns the flag set and the
+// configuration settings.
+func fileFlags(fs *flag.FlagSet, userConfig string, file string, opts *fix.Options) (*flag.FlagSet, map[string]setting, error) {
+	*opts = fix.DefaultOptions()
+	ffs := flag.NewFlagSet(file, flag.ContinueOnError)
+	opts.BindFlags(ffs)
+
+	settings, err := loadConfig(ffs, userConfig, file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading configuration: %w", err)
+	}
+	if err := applyConfig(ffs, settings); err != nil {
+		return nil, nil, fmt.Errorf("error applying configuration: %w", err)
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if ffs.Lookup(f.Name) != nil && err == nil {
+			err = ffs.Set(f.Name, f.Value.String())
+		}
+	})
+	return ffs, settings, err
+}
+
+// fileOptions returns the validated options for a file (see fileFlags).
+func fileOptions(fs *flag.FlagSet, userConfig string, file string) (fix.Options, error) {
+	var opts fix.Options
+	if _, _, err := fileFlags(fs, userConfig, file, &opts); err != nil {
+		return fix.Options{}, err
+	}
+	return opts, opts.Validate()
+}
+
+// planCommand implements "videofix plan": write the plans for all files
+// to a JSON file.
+func planCommand(ctx context.Context, args []string) error {
+	fs, config := optionFlags("plan")
+	out := fs.String("out", "plan.json", "Output file for the plans")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	files, err := inputFiles(fs.Args())
+	if err != nil {
+		return err
+	}
+
+	tools := toolChecker{}
+	var plans []fix.Plan
+	failed := 0
+	for i, file := range files {
+		if err := interrupted(ctx, i, len(files)); err != nil {
+			return err
+		}
+		// Plans may be applied from another directory, so all their paths
+		// must be absolute.
+		file, err := filepath.Abs(file)
+		if err != nil {
+			return err
+		}
+		opts, err := fileOptions(fs, *config, file)
+		if err == nil && opts.OutputDir != "" {
+			opts.OutputDir, err = filepath.Abs(opts.OutputDir)
+		}
+		if err == nil {
+			err = tools.check(ctx, &opts)
+		}
+		if err == nil {
+			var plan fix.Plan
+			if plan, err = makePlan(ctx, file, opts); err == nil {
+				printPlan(plan)
+				plans = append(plans, plan)
+			}
+		}
+		if err != nil {
+			log.Printf("%s: ERROR: %v", file, err)
+			failed++
+		}
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if err := fix.WritePlans(f, plans); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	log.Printf("Wrote %d plan(s) to %s", len(plans), *out)
+
+	if failed > 0 {
+		return fmt.Errorf("unable to plan %d of %d file(s)", failed, len(files))
+	}
+	return nil
+}
+
+// readPlansFile reads the plans saved in a file.
+func readPlansFile(path string) ([]fix.Plan, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	plans, err := fix.ReadPlans(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return plans, nil
+}
+
+// applyCommand implements "videofix apply": execute the plans saved by
+// "videofix plan".
+func applyCommand(ctx context.Context, args []string) error {
+	fs := newFlagSet("apply")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	var plans []fix.Plan
+	for _, path := range fs.Args() {
+		p, err := readPlansFile(path)
+		if err != nil {
+			return err
+		}
+		plans = append(plans, p...)
+	}
+	tools := toolChecker{}
+	failed := 0
+	for i, plan := range plans {
+		if err := interrupted(ctx, i, len(plans)); err != nil {
+			return err
+		}
+		printPlan(plan)
+		err := tools.check(ctx, &plan.Options)
+		if err == nil {
+			err = runPlan(ctx, plan, progressPrinter(batch{i, len(plans)}))
+		}
+		if err != nil {
+			log.Printf("%s: ERROR: %v", plan.Input, err)
+			failed++
+			continue
+		}
+		log.Printf("%s: Operation successful.", plan.Input)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d plan(s) failed", failed, len(plans))
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"path/filepath"
+	"testing"
+
+	"github.com/marcopaganini/videofix/fix"
+)
+
+func TestFileOptions(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "anime", dirConfigName), "lang: jpn\ntranscode: [E-AC-3, DTS]\n")
+
+	testCases := []struct {
+		name          string
+		args          []string
+		file          string
+		expectedLang  string
+		expectedPrune bool
+		expectedCodec int
+	}{
+		{
+			name:          "Defaults",
+			file:          filepath.Join(dir, "movies", "movie.mkv"),
+			expectedLang:  "eng",
+			expectedCodec: 1,
+		},
+		{
+			name:          "Directory configuration",
+			args:          []string{"--prune"},
+			file:          filepath.Join(dir, "anime", "show.mkv"),
+			expectedLang:  "jpn",
+			expectedPrune: true,
+			expectedCodec: 2,
+		},
+		{
+			name:          "Command line wins",
+			args:          []string{"--lang", "spa"},
+			file:          filepath.Join(dir, "anime", "show.mkv"),
+			expectedLang:  "spa",
+			expectedCodec: 2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("plan", flag.ContinueOnError)
+			defaults := fix.DefaultOptions()
+			defaults.BindFlags(fs)
+			if err := fs.Parse(tc.args); err != nil {
+				t.Fatal(err)
+			}
+			opts, err := fileOptions(fs, "", tc.file)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if opts.Lang != tc.expectedLang || opts.Prune != tc.expectedPrune || len(opts.Transcode) != tc.expectedCodec {
+				t.Errorf("unexpected options: lang=%s prune=%v transcode=%v", opts.Lang, opts.Prune, opts.Transcode)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+// Progress display.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/marcopaganini/videofix/fix"
+)
+
+// progressBarWidth is the width of the batch progress bar, in characters.
+const progressBarWidth = 20
+
+// batch holds the position of the current file in a list of files.
+type batch struct {
+	n     int // Current file, starting at zero.
+	total int
+}
+
+// isTerminal returns true if f is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// formatDuration formats a duration as h:mm:ss.
+func formatDuration(d time.Duration) string {
+	s := int(d.Round(time.Second).Seconds())
+	return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+}
+
+// progressLine returns a line with the percentage, speed and ETA of the
+// current file, followed by a bar with the progress of the whole batch.
+func progressLine(p fix.Progress, b batch) string {
+	var file float64
+	status := formatDuration(p.Position)
+	if pct := p.Percent(); pct >= 0 {
+		file = pct / 100
+		status = fmt.Sprintf("%5.1f%%", pct)
+	}
+	speed := "-"
+	if p.Speed > 0 {
+		speed = fmt.Sprintf("%.2fx", p.Speed)
+	}
+	eta := "-"
+	if d := p.ETA(); d >= 0 {
+		eta = formatDuration(d)
+	}
+
+	done := int(progressBarWidth * (float64(b.n) + file) / float64(b.total))
+	bar := strings.Repeat("#", done) + strings.Repeat("-", progressBarWidth-done)
+	return fmt.Sprintf("  %s  speed %s  ETA %s  [%s] %d/%d", status, speed, eta, bar, b.n+1, b.total)
+}
+
+// progressPrinter returns a function to show the progress of the current
+// file in a batch. On terminals, a single line is updated in place.
+// Otherwise, a line is printed every 10%.
+func progressPrinter(b batch) fix.ProgressFunc {
+	tty := isTerminal(os.Stderr)
+	last := -1
+	return func(p fix.Progress) {
+		line := progressLine(p, b)
+		if tty {
+			fmt.Fprintf(os.Stderr, "\r%s\033[K", line)
+			if p.Done {
+				fmt.Fprintln(os.Stderr)
+			}
+			return
+		}
+		if step := int(p.Percent()) / 10; p.Done || step > last {
+			last = step
+			log.Println(line)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/marcopaganini/videofix/fix"
+)
+
+func TestProgressLine(t *testing.T) {
+	testCases := []struct {
+		name     string
+		progress fix.Progress
+		batch    batch
+		expected string
+	}{
+		{
+			name:     "Single file",
+			progress: fix.Progress{Position: 30 * time.Minute, Duration: time.Hour, Speed: 1.5},
+			batch:    batch{n: 0, total: 1},
+			expected: "   50.0%  speed 1.50x  ETA 0:20:00  [##########----------] 1/1",
+		},
+		{
+			name:     "Second of four files",
+			progress: fix.Progress{Position: 30 * time.Minute, Duration: time.Hour, Speed: 1.5},
+			batch:    batch{n: 1, total: 4},
+			expected: "   50.0%  speed 1.50x  ETA 0:20:00  [#######-------------] 2/4",
+		},
+		{
+			name:     "Unknown duration and speed",
+			progress: fix.Progress{Position: 90 * time.Second},
+			batch:    batch{n: 0, total: 2},
+			expected: "  0:01:30  speed -  ETA -  [--------------------] 1/2",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if result := progressLine(tc.progress, tc.batch); result != tc.expected {
+				t.Errorf("expected:\n%q\ngot:\n%q", tc.expected, result)
+			}
+		})
+	}
+}
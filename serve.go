@@ -0,0 +1,498 @@
+// HTTP API.
+//
+// "videofix serve" runs files through the fix pipeline on request:
+//
+//	POST   /jobs       Queue a job: {"path": "...", "options": {...}, "force": false}
+//	GET    /jobs       List all jobs.
+//	GET    /jobs/{id}  Show a job, with the progress while running.
+//	DELETE /jobs/{id}  Cancel a queued or running job.
+//
+//	POST   /webhook    Queue a job for a file imported by Sonarr or Radarr.
+//
+// Options use the flag names as keys, like configuration files, and
+// override the configuration files and the serve command line. Jobs run one
+// at a time and are saved to a file, so queued jobs (and jobs interrupted
+// by a shutdown) run again when the server restarts.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/marcopaganini/videofix/fix"
+)
+
+// Job status values.
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobDone      = "done"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+const (
+	defaultListen = "localhost:8080"
+	// maxFinishedJobs is the maximum number of finished jobs kept.
+	maxFinishedJobs = 100
+	// shutdownTimeout is the maximum time to wait for open requests when
+	// the server stops.
+	shutdownTimeout = 5 * time.Second
+)
+
+// jobProgress holds the progress of a running job.
+type jobProgress struct {
+	Percent float64 `json:"percent"` // -1 if unknown.
+	Speed   float64 `json:"speed"`
+	ETA     string  `json:"eta,omitempty"`
+}
+
+// job holds a request to fix a file and its status.
+type job struct {
+	ID       string            `json:"id"`
+	Path     string            `json:"path"`
+	Options  map[string]string `json:"options,omitempty"`
+	Force    bool              `json:"force,omitempty"`
+	Rescan   *rescanRequest    `json:"rescan,omitempty"`
+	Status   string            `json:"status"`
+	Error    string            `json:"error,omitempty"`
+	Progress *jobProgress      `json:"progress,omitempty"`
+	Created  time.Time         `json:"created"`
+	Started  time.Time         `json:"started,omitzero"`
+	Finished time.Time         `json:"finished,omitzero"`
+}
+
+// finished returns true if the job will not run again.
+func (j *job) finished() bool {
+	return j.Status == jobDone || j.Status == jobFailed || j.Status == jobCancelled
+}
+
+// jobRequest is the body of POST /jobs.
+type jobRequest struct {
+	Path    string         `json:"path"`
+	Options map[string]any `json:"options"`
+	Force   bool           `json:"force"`
+}
+
+// executor runs a job, calling fn with the progress. It must return when
+// the context is cancelled.
+type executor func(ctx context.Context, j job, fn fix.ProgressFunc) error
+
+// server holds the job queue. Jobs are kept in creation order.
+type server struct {
+	run  executor
+	path string // Jobs file, or blank to keep the jobs in memory.
+	arr  arrConfig
+
+	mu        sync.Mutex
+	jobs      []*job
+	nextID    int
+	cancel    context.CancelFunc // Cancels the running job.
+	cancelled bool               // The running job was cancelled.
+	wake      chan struct{}
+}
+
+// newServer returns a server running jobs with run, loading the jobs saved
+// in path. Jobs interrupted while running are queued again.
+func newServer(path string, run executor) (*server, error) {
+	s := &server{run: run, path: path, nextID: 1, wake: make(chan struct{}, 1)}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.jobs); err != nil {
+		return nil, fmt.Errorf("error parsing jobs file %s: %w", path, err)
+	}
+	for _, j := range s.jobs {
+		if j.Status == jobRunning {
+			j.Status, j.Progress = jobQueued, nil
+		}
+		if id, err := strconv.Atoi(j.ID); err == nil && id >= s.nextID {
+			s.nextID = id + 1
+		}
+	}
+	return s, nil
+}
+
+// save writes the jobs file. It must be called with the lock held.
+func (s *server) save() {
+	if s.path == "" {
+		return
+	}
+	data, err := json.MarshalIndent(s.jobs, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(s.path, data)
+	}
+	if err != nil {
+		log.Printf("WARNING: unable to save jobs: %v", err)
+	}
+}
+
+// find returns the job with the given ID, or nil. It must be called with
+// the lock held.
+func (s *server) find(id string) *job {
+	for _, j := range s.jobs {
+		if j.ID == id {
+			return j
+		}
+	}
+	return nil
+}
+
+// add queues a new job with the path, options, force and rescan settings
+// of j and returns a copy of it.
+func (s *server) add(j job) job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j = job{ID: strconv.Itoa(s.nextID), Path: j.Path, Options: j.Options, Force: j.Force, Rescan: j.Rescan, Status: jobQueued, Created: time.Now()}
+	s.nextID++
+	s.jobs = append(s.jobs, &j)
+	s.save()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return j
+}
+
+// next starts the first queued job and returns a copy of it, or false if
+// there are no queued jobs.
+func (s *server) next(cancel context.CancelFunc) (job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, j := range s.jobs {
+		if j.Status == jobQueued {
+			j.Status, j.Started, j.Progress = jobRunning, time.Now(), &jobProgress{Percent: -1}
+			s.cancel, s.cancelled = cancel, false
+			s.save()
+			return *j, true
+		}
+	}
+	return job{}, false
+}
+
+// progress updates the progress of a running job.
+func (s *server) progress(id string, p fix.Progress) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if j := s.find(id); j != nil && j.Status == jobRunning {
+		j.Progress = &jobProgress{Percent: p.Percent(), Speed: p.Speed}
+		if eta := p.ETA(); eta >= 0 {
+			j.Progress.ETA = formatDuration(eta)
+		}
+	}
+}
+
+// finish records the outcome of a job. Jobs interrupted by the server
+// stopping (stopping is set) are queued again.
+func (s *server) finish(id string, err error, stopping bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cancel = nil
+	j := s.find(id)
+	if j == nil {
+		return
+	}
+	j.Progress = nil
+	switch {
+	case stopping:
+		j.Status, j.Started = jobQueued, time.Time{}
+		s.save()
+		return
+	case err == nil:
+		j.Status = jobDone
+	case s.cancelled:
+		j.Status = jobCancelled
+	default:
+		j.Status, j.Error = jobFailed, err.Error()
+	}
+	j.Finished = time.Now()
+	s.prune()
+	s.save()
+}
+
+// prune removes the oldest finished jobs beyond maxFinishedJobs. It must
+// be called with the lock held.
+func (s *server) prune() {
+	finished := 0
+	for _, j := range s.jobs {
+		if j.finished() {
+			finished++
+		}
+	}
+	var jobs []*job
+	for _, j := range s.jobs {
+		if j.finished() && finished > maxFinishedJobs {
+			finished--
+			continue
+		}
+		jobs = append(jobs, j)
+	}
+	s.jobs = jobs
+}
+
+// cancelJob cancels a queued or running job and returns a copy of it.
+func (s *server) cancelJob(id string) (job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j := s.find(id)
+	switch {
+	case j == nil:
+		return job{}, fs.ErrNotExist
+	case j.finished():
+		return *j, fmt.Errorf("job %s already %s", id, j.Status)
+	case j.Status == jobRunning:
+		// The status changes when the executor returns.
+		s.cancelled = true
+		if s.cancel != nil {
+			s.cancel()
+		}
+	default:
+		j.Status, j.Finished = jobCancelled, time.Now()
+		s.save()
+	}
+	return *j, nil
+}
+
+// work runs the queued jobs, one at a time, until the context ends.
+func (s *server) work(ctx context.Context) {
+	for {
+		jctx, cancel := context.WithCancel(ctx)
+		j, ok := s.next(cancel)
+		if !ok {
+			cancel()
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.wake:
+				continue
+			}
+		}
+		log.Printf("%s: Starting job %s.", j.Path, j.ID)
+		err := s.run(jctx, j, func(p fix.Progress) { s.progress(j.ID, p) })
+		cancel()
+		s.finish(j.ID, err, ctx.Err() != nil)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil && j.Rescan != nil {
+			s.rescan(ctx, j)
+		}
+	}
+}
+
+// writeJSON writes v as the JSON response.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+// writeError writes an error as the JSON response.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// jobValues validates the options of a job request and returns them as
+// flag values.
+func jobValues(raw map[string]any) (map[string]string, error) {
+	ffs := flag.NewFlagSet("job", flag.ContinueOnError)
+	opts := fix.DefaultOptions()
+	opts.BindFlags(ffs)
+	values, err := flagValues(ffs, raw)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range values {
+		if userOnlyFlags[name] {
+			return nil, fmt.Errorf("setting %q not allowed in jobs", name)
+		}
+		if err := ffs.Set(name, value); err != nil {
+			return nil, fmt.Errorf("invalid value for %q: %w", name, err)
+		}
+	}
+	return values, opts.Validate()
+}
+
+// handler returns the HTTP handler for the API.
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", func(w http.ResponseWriter, r *http.Request) {
+		var req jobRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+			return
+		}
+		if req.Path == "" {
+			writeError(w, http.StatusBadRequest, errors.New("missing path"))
+			return
+		}
+		path, err := filepath.Abs(req.Path)
+		if err == nil {
+			_, err = os.Stat(path)
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("file not found: %s", req.Path))
+			return
+		}
+		values, err := jobValues(req.Options)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		j := s.add(job{Path: path, Options: values, Force: req.Force})
+		w.Header().Set("Location", "/jobs/"+j.ID)
+		writeJSON(w, http.StatusCreated, j)
+	})
+	mux.HandleFunc("POST /webhook", s.handleWebhook)
+	mux.HandleFunc("GET /jobs", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		jobs := []job{}
+		for _, j := range s.jobs {
+			jobs = append(jobs, *j)
+		}
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, jobs)
+	})
+	mux.HandleFunc("GET /jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		var j job
+		found := s.find(r.PathValue("id"))
+		if found != nil {
+			j = *found
+		}
+		s.mu.Unlock()
+		if found == nil {
+			writeError(w, http.StatusNotFound, fmt.Errorf("job %s not found", r.PathValue("id")))
+			return
+		}
+		writeJSON(w, http.StatusOK, j)
+	})
+	mux.HandleFunc("DELETE /jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		j, err := s.cancelJob(r.PathValue("id"))
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			writeError(w, http.StatusNotFound, fmt.Errorf("job %s not found", r.PathValue("id")))
+		case err != nil:
+			writeError(w, http.StatusConflict, err)
+		default:
+			writeJSON(w, http.StatusOK, j)
+		}
+	})
+	return mux
+}
+
+// defaultJobsFile returns the path to the default jobs file.
+func defaultJobsFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "videofix", "jobs.json")
+}
+
+// jobOptions returns the options for a job: the options for the file (see
+// fileOptions), overridden by the options in the job.
+func jobOptions(fs *flag.FlagSet, userConfig string, j job) (fix.Options, error) {
+	var opts fix.Options
+	ffs, _, err := fileFlags(fs, userConfig, j.Path, &opts)
+	if err != nil {
+		return fix.Options{}, err
+	}
+	for name, value := range j.Options {
+		if err := ffs.Set(name, value); err != nil {
+			return fix.Options{}, fmt.Errorf("invalid value for %q: %w", name, err)
+		}
+	}
+	return opts, opts.Validate()
+}
+
+// serveCommand implements "videofix serve": run the HTTP API.
+func serveCommand(ctx context.Context, args []string) error {
+	fs, config := optionFlags("serve")
+	listen := fs.String("listen", defaultListen, "Address to listen on")
+	jobsFile := fs.String("jobs", defaultJobsFile(), "File holding the job queue (blank to keep it in memory)")
+	state := fs.String("state", defaultStateFile(), "State file recording the fixed files (blank to disable)")
+	var paths pathMap
+	fs.Var(&paths, "path-map", "Translate webhook paths, as from=to (can be repeated)")
+	sonarr := arrApp{}
+	fs.StringVar(&sonarr.URL, "sonarr-url", "", "Sonarr URL, to rescan series after fixing files")
+	fs.StringVar(&sonarr.APIKey, "sonarr-api-key", os.Getenv("VIDEOFIX_SONARR_API_KEY"), "Sonarr API key")
+	radarr := arrApp{}
+	fs.StringVar(&radarr.URL, "radarr-url", "", "Radarr URL, to rescan movies after fixing files")
+	fs.StringVar(&radarr.APIKey, "radarr-api-key", os.Getenv("VIDEOFIX_RADARR_API_KEY"), "Radarr API key")
+	fs.Parse(args)
+
+	if fs.NArg() > 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	db, err := loadState(*state)
+	if err != nil {
+		return err
+	}
+	f := &fixer{fs: fs, config: *config, db: db, tools: toolChecker{}}
+	run := func(ctx context.Context, j job, fn fix.ProgressFunc) error {
+		opts, err := jobOptions(fs, *config, j)
+		if err != nil {
+			return err
+		}
+		jf := *f
+		jf.force = j.Force
+		return jf.run(ctx, j.Path, opts, fn)
+	}
+	s, err := newServer(*jobsFile, run)
+	if err != nil {
+		return err
+	}
+	s.arr = arrConfig{paths: paths, apps: map[string]arrApp{appSonarr: sonarr, appRadarr: radarr}}
+
+	// Stop the worker if the server fails.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	hs := &http.Server{Addr: *listen, Handler: s.handler()}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.work(ctx)
+	}()
+	go func() {
+		<-ctx.Done()
+		sctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		_ = hs.Shutdown(sctx)
+	}()
+
+	log.Printf("Listening on %s.", *listen)
+	err = hs.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	// Wait for the running job to stop.
+	cancel()
+	wg.Wait()
+	log.Printf("Server stopped.")
+	return err
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcopaganini/videofix/fix"
+)
+
+// fakeExecutor runs jobs until released or cancelled.
+type fakeExecutor struct {
+	started chan job
+	release chan error
+}
+
+func (f *fakeExecutor) run(ctx context.Context, j job, fn fix.ProgressFunc) error {
+	fn(fix.Progress{Input: j.Path, Position: 30 * time.Second, Duration: time.Minute, Speed: 2})
+	f.started <- j
+	select {
+	case err := <-f.release:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("ffmpeg conversion failed: %w", fix.ErrCancelled)
+	}
+}
+
+// request sends a request to the test server and returns the status and
+// the body.
+func request(t *testing.T, ts *httptest.Server, method string, path string, body string) (int, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, data
+}
+
+// getJob returns a job from the test server.
+func getJob(t *testing.T, ts *httptest.Server, id string) job {
+	t.Helper()
+	status, data := request(t, ts, "GET", "/jobs/"+id, "")
+	if status != http.StatusOK {
+		t.Fatalf("GET /jobs/%s: expected status 200, got %d: %s", id, status, data)
+	}
+	var j job
+	if err := json.Unmarshal(data, &j); err != nil {
+		t.Fatal(err)
+	}
+	return j
+}
+
+// postJob creates a job for path and returns it.
+func postJob(t *testing.T, ts *httptest.Server, path string) job {
+	t.Helper()
+	status, data := request(t, ts, "POST", "/jobs", fmt.Sprintf(`{"path": %q, "options": {"lang": "jpn", "transcode": ["E-AC-3", "DTS"]}}`, path))
+	if status != http.StatusCreated {
+		t.Fatalf("POST /jobs: expected status 201, got %d: %s", status, data)
+	}
+	var j job
+	if err := json.Unmarshal(data, &j); err != nil {
+		t.Fatal(err)
+	}
+	return j
+}
+
+// waitStatus waits for a job to reach the status.
+func waitStatus(t *testing.T, ts *httptest.Server, id string, status string) job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		j := getJob(t, ts, id)
+		if j.Status == status {
+			return j
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s: expected status %s, got %s", id, status, j.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServer(t *testing.T) {
+	dir := t.TempDir()
+	movie := filepath.Join(dir, "movie.mkv")
+	writeFile(t, movie, "movie")
+
+	fake := &fakeExecutor{started: make(chan job), release: make(chan error)}
+	s, err := newServer("", fake.run)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.work(ctx)
+
+	// Running job, with progress.
+	first := postJob(t, ts, movie)
+	if first.ID != "1" || first.Path != movie || first.Options["transcode"] != "E-AC-3,DTS" {
+		t.Fatalf("unexpected job: %+v", first)
+	}
+	<-fake.started
+	if j := getJob(t, ts, first.ID); j.Status != jobRunning || j.Progress == nil || j.Progress.Percent != 50 || j.Progress.ETA != "0:00:15" {
+		t.Errorf("unexpected running job: %+v (progress %+v)", j, j.Progress)
+	}
+
+	// Cancel a queued job.
+	second := postJob(t, ts, movie)
+	if status, data := request(t, ts, "DELETE", "/jobs/"+second.ID, ""); status != http.StatusOK {
+		t.Errorf("DELETE /jobs/%s: expected status 200, got %d: %s", second.ID, status, data)
+	}
+	waitStatus(t, ts, second.ID, jobCancelled)
+
+	fake.release <- nil
+	if j := waitStatus(t, ts, first.ID, jobDone); j.Progress != nil || j.Finished.IsZero() {
+		t.Errorf("unexpected finished job: %+v", j)
+	}
+
+	// Cancel a running job.
+	third := postJob(t, ts, movie)
+	<-fake.started
+	if status, data := request(t, ts, "DELETE", "/jobs/"+third.ID, ""); status != http.StatusOK {
+		t.Errorf("DELETE /jobs/%s: expected status 200, got %d: %s", third.ID, status, data)
+	}
+	waitStatus(t, ts, third.ID, jobCancelled)
+
+	// Failed job.
+	fourth := postJob(t, ts, movie)
+	<-fake.started
+	fake.release <- errors.New("conversion failed")
+	if j := waitStatus(t, ts, fourth.ID, jobFailed); j.Error != "conversion failed" {
+		t.Errorf("expected error %q, got %q", "conversion failed", j.Error)
+	}
+
+	if status, _ := request(t, ts, "DELETE", "/jobs/"+first.ID, ""); status != http.StatusConflict {
+		t.Errorf("DELETE finished job: expected status 409, got %d", status)
+	}
+	if status, _ := request(t, ts, "GET", "/jobs/99", ""); status != http.StatusNotFound {
+		t.Errorf("GET missing job: expected status 404, got %d", status)
+	}
+	status, data := request(t, ts, "GET", "/jobs", "")
+	var jobs []job
+	if err := json.Unmarshal(data, &jobs); err != nil || status != http.StatusOK {
+		t.Fatalf("GET /jobs: unexpected response %d: %s", status, data)
+	}
+	var result []string
+	for _, j := range jobs {
+		result = append(result, j.ID+":"+j.Status)
+	}
+	if expected := "1:done 2:cancelled 3:cancelled 4:failed"; strings.Join(result, " ") != expected {
+		t.Errorf("expected jobs %q, got %q", expected, strings.Join(result, " "))
+	}
+}
+
+func TestServerBadRequests(t *testing.T) {
+	dir := t.TempDir()
+	movie := filepath.Join(dir, "movie.mkv")
+	writeFile(t, movie, "movie")
+
+	testCases := []struct {
+		name string
+		body string
+	}{
+		{name: "Invalid JSON", body: `{"path":`},
+		{name: "Missing path", body: `{}`},
+		{name: "Missing file", body: fmt.Sprintf(`{"path": %q}`, filepath.Join(dir, "missing.mkv"))},
+		{name: "Unknown option", body: fmt.Sprintf(`{"path": %q, "options": {"speed": "fast"}}`, movie)},
+		{name: "Invalid value", body: fmt.Sprintf(`{"path": %q, "options": {"prune": "maybe"}}`, movie)},
+		{name: "Hook", body: fmt.Sprintf(`{"path": %q, "options": {"post-hook": "rm -rf /"}}`, movie)},
+		{name: "Program path", body: fmt.Sprintf(`{"path": %q, "options": {"ffmpeg": "/tmp/evil"}}`, movie)},
+		{name: "Invalid option", body: fmt.Sprintf(`{"path": %q, "options": {"covers": "keep"}}`, movie)},
+	}
+
+	s, err := newServer("", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if status, data := request(t, ts, "POST", "/jobs", tc.body); status != http.StatusBadRequest {
+				t.Errorf("expected status 400, got %d: %s", status, data)
+			}
+		})
+	}
+	if len(s.jobs) != 0 {
+		t.Errorf("expected no jobs, got %d", len(s.jobs))
+	}
+}
+
+func TestServerPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.json")
+	s, err := newServer(path, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s.add(job{Path: "/movies/a.mkv"})
+	s.add(job{Path: "/movies/b.mkv", Options: map[string]string{"lang": "jpn"}, Force: true})
+	if _, ok := s.next(func() {}); !ok {
+		t.Fatalf("expected a queued job")
+	}
+
+	// The running job is queued again after a restart.
+	s, err = newServer(path, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(s.jobs) != 2 || s.jobs[0].Status != jobQueued || s.jobs[1].Status != jobQueued || !s.jobs[1].Force {
+		t.Fatalf("unexpected jobs: %+v %+v", s.jobs[0], s.jobs[1])
+	}
+	if j := s.add(job{Path: "/movies/c.mkv"}); j.ID != "3" {
+		t.Errorf("expected job ID 3, got %s", j.ID)
+	}
+}
@@ -0,0 +1,188 @@
+// Processing state.
+//
+// The state file (~/.config/videofix/state.json by default) records the
+// outcome of every file processed by "videofix fix", keyed by the input
+// file, with the output file, the size and modification time of the input
+// afterwards and a hash of the settings used. Files fixed successfully are
+// skipped until they change, their output disappears or the settings
+// change. "videofix history" shows the records of a file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/marcopaganini/videofix/fix"
+)
+
+const (
+	outcomeFixed   = "fixed"
+	outcomeFailed  = "failed"
+	outcomeSkipped = "skipped"
+	// maxHistory is the maximum number of records kept for each file.
+	maxHistory = 20
+)
+
+// record holds the outcome of processing a file.
+type record struct {
+	Time     time.Time `json:"time"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mtime"`
+	Settings string    `json:"settings"`
+	Output   string    `json:"output,omitempty"`
+	Outcome  string    `json:"outcome"`
+	Reason   string    `json:"reason,omitempty"`
+}
+
+// stateDB holds the records of all files, keyed by absolute path, from the
+// oldest to the newest.
+type stateDB struct {
+	path  string
+	Files map[string][]record `json:"files"`
+}
+
+// defaultStateFile returns the path to the default state file.
+func defaultStateFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "videofix", "state.json")
+}
+
+// loadState reads the state file. A missing file returns an empty state,
+// and a blank path returns a state that is never saved.
+func loadState(path string) (*stateDB, error) {
+	db := &stateDB{path: path, Files: map[string][]record{}}
+	if path == "" {
+		return db, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return db, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, db); err != nil {
+		return nil, fmt.Errorf("error parsing state file %s: %w", path, err)
+	}
+	if db.Files == nil {
+		db.Files = map[string][]record{}
+	}
+	return db, nil
+}
+
+// save writes the state file.
+func (db *stateDB) save() error {
+	if db.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(db, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(db.path, data)
+}
+
+// writeFileAtomic writes a file (and its directory, if needed) through a
+// temporary file, so an interrupted write never leaves a truncated file.
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// history returns the records of a file, from the oldest to the newest.
+func (db *stateDB) history(file string) []record {
+	if abs, err := filepath.Abs(file); err == nil {
+		file = abs
+	}
+	return db.Files[file]
+}
+
+// add records the outcome of processing a file into output (blank if no
+// output was written) and saves the state. The record is keyed by the input
+// file, with its current size and modification time. If the input was
+// replaced by an output with a different name (MP4 files fixed in place),
+// the output gets the same record.
+func (db *stateDB) add(file string, output string, opts fix.Options, outcome string, reason string) error {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	if output != "" {
+		if output, err = filepath.Abs(output); err != nil {
+			return err
+		}
+	}
+	keys := []string{file}
+	if _, err := os.Stat(file); errors.Is(err, fs.ErrNotExist) && output != "" && output != file {
+		keys = append(keys, output)
+	}
+	for _, key := range keys {
+		r := record{Time: time.Now(), Settings: opts.Hash(), Outcome: outcome, Reason: reason, Output: output}
+		if fi, err := os.Stat(key); err == nil {
+			r.Size, r.ModTime = fi.Size(), fi.ModTime()
+		}
+		records := append(db.Files[key], r)
+		if len(records) > maxHistory {
+			records = records[len(records)-maxHistory:]
+		}
+		db.Files[key] = records
+	}
+	return db.save()
+}
+
+// unchanged returns the last record of the file if it was fixed with the
+// same settings, has not changed since and its output still exists.
+func (db *stateDB) unchanged(file string, opts fix.Options) (record, bool) {
+	records := db.history(file)
+	if len(records) == 0 {
+		return record{}, false
+	}
+	last := records[len(records)-1]
+	fi, err := os.Stat(file)
+	if err != nil || last.Outcome != outcomeFixed || last.Settings != opts.Hash() {
+		return record{}, false
+	}
+	if fi.Size() != last.Size || !fi.ModTime().Equal(last.ModTime) {
+		return record{}, false
+	}
+	if last.Output != "" {
+		if _, err := os.Stat(last.Output); err != nil {
+			return record{}, false
+		}
+	}
+	return last, true
+}
+
+// fixedFile returns the name of the file produced by a plan. MP4 files are
+// converted to MKV and renamed.
+func fixedFile(plan fix.Plan) string {
+	if strings.ToLower(filepath.Ext(plan.Input)) == ".mp4" {
+		return strings.TrimSuffix(plan.Input, ".mp4") + ".mkv"
+	}
+	return plan.Output
+}
+
+// recordOutcome adds a record to the state, logging (but otherwise
+// ignoring) errors saving it.
+func recordOutcome(db *stateDB, file string, output string, opts fix.Options, outcome string, reason string) {
+	if err := db.add(file, output, opts, outcome, reason); err != nil {
+		log.Printf("%s: WARNING: unable to save state: %v", file, err)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/marcopaganini/videofix/fix"
+)
+
+func TestStateUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	movie := filepath.Join(dir, "movie.mkv")
+	opts := fix.DefaultOptions()
+	other := fix.DefaultOptions()
+	other.Lang = "jpn"
+
+	testCases := []struct {
+		name     string
+		outcome  string
+		modify   func()
+		opts     fix.Options
+		expected bool
+	}{
+		{name: "Fixed", outcome: outcomeFixed, opts: opts, expected: true},
+		{name: "Failed", outcome: outcomeFailed, opts: opts},
+		{name: "Different settings", outcome: outcomeFixed, opts: other},
+		{name: "File changed", outcome: outcomeFixed, modify: func() { writeFile(t, movie, "changed movie") }, opts: opts},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			writeFile(t, movie, "movie")
+			db, err := loadState(filepath.Join(dir, tc.name, "state.json"))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := db.add(movie, movie, opts, tc.outcome, "reason"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tc.modify != nil {
+				tc.modify()
+			}
+			// Reload to check the saved state.
+			if db, err = loadState(db.path); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if _, ok := db.unchanged(movie, tc.opts); ok != tc.expected {
+				t.Errorf("expected unchanged=%v, got %v", tc.expected, ok)
+			}
+		})
+	}
+}
+
+func TestStateHistory(t *testing.T) {
+	dir := t.TempDir()
+	movie := filepath.Join(dir, "movie.mkv")
+	writeFile(t, movie, "movie")
+
+	db, err := loadState("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < maxHistory+5; i++ {
+		if err := db.add(movie, "", fix.DefaultOptions(), outcomeFailed, "error"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := db.add(movie, movie, fix.DefaultOptions(), outcomeFixed, "no changes"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	records := db.history(movie)
+	if len(records) != maxHistory {
+		t.Fatalf("expected %d records, got %d", maxHistory, len(records))
+	}
+	if last := records[len(records)-1]; last.Outcome != outcomeFixed || last.Size != 5 {
+		t.Errorf("unexpected last record: %+v", last)
+	}
+	if records := db.history(filepath.Join(dir, "other.mkv")); len(records) != 0 {
+		t.Errorf("expected no records, got %+v", records)
+	}
+}
+
+func TestStateOutput(t *testing.T) {
+	dir := t.TempDir()
+	opts := fix.DefaultOptions()
+	db, err := loadState("")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Fixed into another directory: keyed by the input, skipped while the
+	// output exists.
+	movie := filepath.Join(dir, "movie.mkv")
+	output := filepath.Join(dir, "out", "movie.mkv")
+	writeFile(t, movie, "movie")
+	writeFile(t, output, "fixed movie")
+	if err := db.add(movie, output, opts, outcomeFixed, "reason"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if last, ok := db.unchanged(movie, opts); !ok || last.Output != output {
+		t.Errorf("expected unchanged with output %s, got %v (%+v)", output, ok, last)
+	}
+	if records := db.history(output); len(records) != 0 {
+		t.Errorf("expected no records for the output, got %+v", records)
+	}
+	if err := os.Remove(output); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := db.unchanged(movie, opts); ok {
+		t.Errorf("expected changed after removing the output")
+	}
+
+	// MP4 fixed in place: both names get the record.
+	mp4 := filepath.Join(dir, "video.mp4")
+	mkv := filepath.Join(dir, "video.mkv")
+	writeFile(t, mkv, "fixed video")
+	if err := db.add(mp4, mkv, opts, outcomeFixed, "convert MP4 to MKV"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, file := range []string{mp4, mkv} {
+		if records := db.history(file); len(records) != 1 || records[0].Output != mkv {
+			t.Errorf("%s: expected one record with output %s, got %+v", file, mkv, records)
+		}
+	}
+	if _, ok := db.unchanged(mkv, opts); !ok {
+		t.Errorf("expected %s unchanged", mkv)
+	}
+}
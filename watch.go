@@ -0,0 +1,321 @@
+// Watch mode.
+//
+// "videofix watch" watches directories (and their subdirectories) for new
+// or changed video files and fixes them. Downloads are only processed once
+// finished: files are ignored while a partial download file exists next to
+// them (e.g. movie.mkv.part or movie.mkv.aria2), and must keep the same size
+// and modification time for the settle time. Settled files go into a
+// bounded queue and are fixed one at a time. Files written by videofix
+// itself are ignored until something else changes them, even with --force.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/marcopaganini/videofix/fix"
+)
+
+const (
+	defaultSettle    = 30 * time.Second
+	defaultQueueSize = 100
+	// maxCheckInterval is the maximum time between checks of the files
+	// waiting to settle.
+	maxCheckInterval = time.Second
+)
+
+// partialExtensions lists the extensions used by downloaders for partial
+// files, either renamed to the final name when done or kept next to it.
+var partialExtensions = []string{".part", ".partial", ".crdownload", ".download", ".!qb", ".aria2", ".tmp"}
+
+// pendingFile holds the state of a file waiting to settle.
+type pendingFile struct {
+	size    int64
+	modTime time.Time
+	since   time.Time // Last change of size or modification time.
+	full    bool      // Queue full reported.
+}
+
+// watcher watches directories and calls process for every file that
+// settles.
+type watcher struct {
+	settle   time.Duration
+	interval time.Duration // Time between checks of the pending files.
+	ignore   []string      // Partial download extensions (lowercase).
+	process  func(ctx context.Context, file string)
+
+	fsw     *fsnotify.Watcher
+	pending map[string]*pendingFile
+	queue   chan string
+
+	mu     sync.Mutex
+	queued map[string]bool
+	// written holds the files written by process (see wrote), ignored
+	// while they keep the same size and modification time.
+	written map[string]fix.FileStamp
+}
+
+// newWatcher returns a watcher for the directories and all their
+// subdirectories. Files already in the directories are not processed.
+func newWatcher(dirs []string, settle time.Duration, queueSize int, ignore []string, process func(ctx context.Context, file string)) (*watcher, error) {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("unable to watch directories: %w", err)
+	}
+	w := &watcher{
+		settle:   settle,
+		interval: min(maxCheckInterval, max(settle/4, time.Millisecond)),
+		process:  process,
+		fsw:      fsw,
+		pending:  map[string]*pendingFile{},
+		queue:    make(chan string, queueSize),
+		queued:   map[string]bool{},
+		written:  map[string]fix.FileStamp{},
+	}
+	for _, ext := range ignore {
+		w.ignore = append(w.ignore, strings.ToLower(ext))
+	}
+	for _, dir := range dirs {
+		if err := w.addDir(dir, false); err != nil {
+			fsw.Close()
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// addDir watches dir and its subdirectories. With scan, the files found
+// start waiting to settle (for directories moved into a watched directory).
+func (w *watcher) addDir(dir string, scan bool) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if err := w.fsw.Add(path); err != nil {
+				return fmt.Errorf("unable to watch %s: %w", path, err)
+			}
+			return nil
+		}
+		if scan {
+			w.touch(path, time.Now())
+		}
+		return nil
+	})
+}
+
+// partial returns true if the file is a partial download, or a partial
+// download file exists next to it.
+func (w *watcher) partial(path string) bool {
+	for _, ext := range w.ignore {
+		if strings.ToLower(filepath.Ext(path)) == ext {
+			return true
+		}
+		if _, err := os.Stat(path + ext); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// wrote records a file written while processing another, so the events
+// it causes don't queue it again. It is safe to call from process.
+func (w *watcher) wrote(path string) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.written[path] = fix.FileStamp{Size: fi.Size(), ModTime: fi.ModTime()}
+}
+
+// ownOutput returns true if the file was written by process (see wrote)
+// and has not changed since.
+func (w *watcher) ownOutput(path string, fi os.FileInfo) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	stamp, ok := w.written[path]
+	if !ok {
+		return false
+	}
+	if stamp.Size == fi.Size() && stamp.ModTime.Equal(fi.ModTime()) {
+		return true
+	}
+	delete(w.written, path)
+	return false
+}
+
+// touch starts (or restarts) waiting for a video file to settle.
+func (w *watcher) touch(path string, now time.Time) {
+	if !isVideoFile(path) {
+		return
+	}
+	fi, err := os.Stat(path)
+	if err != nil || w.ownOutput(path, fi) {
+		delete(w.pending, path)
+		return
+	}
+	p, ok := w.pending[path]
+	if !ok || p.size != fi.Size() || !p.modTime.Equal(fi.ModTime()) {
+		w.pending[path] = &pendingFile{size: fi.Size(), modTime: fi.ModTime(), since: now}
+	}
+}
+
+// check queues the pending files that settled. Files still being written
+// start waiting again, and files that can't be queued are kept for the next
+// check.
+func (w *watcher) check(now time.Time) {
+	for path, p := range w.pending {
+		fi, err := os.Stat(path)
+		switch {
+		case err != nil, w.ownOutput(path, fi):
+			delete(w.pending, path)
+			continue
+		case fi.Size() != p.size || !fi.ModTime().Equal(p.modTime) || w.partial(path):
+			p.size, p.modTime, p.since = fi.Size(), fi.ModTime(), now
+			continue
+		case now.Sub(p.since) < w.settle:
+			continue
+		}
+
+		w.mu.Lock()
+		if w.queued[path] {
+			w.mu.Unlock()
+			delete(w.pending, path)
+			continue
+		}
+		select {
+		case w.queue <- path:
+			w.queued[path] = true
+			delete(w.pending, path)
+			log.Printf("%s: Queued.", path)
+		default:
+			if !p.full {
+				log.Printf("%s: Queue full, will retry.", path)
+				p.full = true
+			}
+		}
+		w.mu.Unlock()
+	}
+}
+
+// event handles a filesystem event.
+func (w *watcher) event(ev fsnotify.Event) {
+	switch {
+	case ev.Has(fsnotify.Create):
+		if fi, err := os.Stat(ev.Name); err == nil && fi.IsDir() {
+			if err := w.addDir(ev.Name, true); err != nil {
+				log.Printf("WARNING: %v", err)
+			}
+			return
+		}
+		w.touch(ev.Name, time.Now())
+	case ev.Has(fsnotify.Write), ev.Has(fsnotify.Chmod):
+		w.touch(ev.Name, time.Now())
+	case ev.Has(fsnotify.Remove), ev.Has(fsnotify.Rename):
+		delete(w.pending, ev.Name)
+	}
+}
+
+// work processes the queued files until the context ends.
+func (w *watcher) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case path := <-w.queue:
+			w.mu.Lock()
+			delete(w.queued, path)
+			w.mu.Unlock()
+			w.process(ctx, path)
+		}
+	}
+}
+
+// run watches the directories until the context ends, and waits for the
+// file being processed.
+func (w *watcher) run(ctx context.Context) error {
+	defer w.fsw.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.work(ctx)
+	}()
+	defer wg.Wait()
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev, ok := <-w.fsw.Events:
+			if !ok {
+				return nil
+			}
+			w.event(ev)
+		case err, ok := <-w.fsw.Errors:
+			if !ok {
+				return nil
+			}
+			log.Printf("WARNING: error watching directories: %v", err)
+		case now := <-ticker.C:
+			w.check(now)
+		}
+	}
+}
+
+// watchCommand implements "videofix watch": fix new files in the
+// directories as they arrive.
+func watchCommand(ctx context.Context, args []string) error {
+	fs, config := optionFlags("watch")
+	state := fs.String("state", defaultStateFile(), "State file recording the fixed files (blank to disable)")
+	force := fs.Bool("force", false, "Process files even if unchanged since they were fixed")
+	settle := fs.Duration("settle", defaultSettle, "Time a file must stay unchanged before processing it")
+	queueSize := fs.Int("queue", defaultQueueSize, "Maximum number of files waiting to be processed")
+	ignore := fs.String("ignore-ext", strings.Join(partialExtensions, ","), "Comma separated extensions of partial download files")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	for _, dir := range fs.Args() {
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			return fmt.Errorf("not a directory: %s", dir)
+		}
+	}
+	if *settle <= 0 || *queueSize <= 0 {
+		return fmt.Errorf("--settle and --queue must be positive")
+	}
+	db, err := loadState(*state)
+	if err != nil {
+		return err
+	}
+
+	f := &fixer{fs: fs, config: *config, db: db, force: *force, tools: toolChecker{}}
+	process := func(ctx context.Context, file string) {
+		_ = f.fix(ctx, file, batch{0, 1})
+	}
+	w, err := newWatcher(fs.Args(), *settle, *queueSize, strings.Split(*ignore, ","), process)
+	if err != nil {
+		return err
+	}
+	f.wrote = w.wrote
+	log.Printf("Watching %s.", strings.Join(fs.Args(), ", "))
+	err = w.run(ctx)
+	log.Printf("Stopped watching.")
+	return err
+}
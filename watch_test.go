@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatcherSettle(t *testing.T) {
+	dir := t.TempDir()
+	movie := filepath.Join(dir, "movie.mkv")
+	writeFile(t, movie, "movie")
+	writeFile(t, filepath.Join(dir, "notes.txt"), "notes")
+
+	w, err := newWatcher([]string{dir}, time.Minute, 1, partialExtensions, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer w.fsw.Close()
+
+	start := time.Now()
+	w.touch(movie, start)
+	w.touch(filepath.Join(dir, "notes.txt"), start)
+	if len(w.pending) != 1 {
+		t.Fatalf("expected only the video file to be pending, got %v", w.pending)
+	}
+
+	// Still being written.
+	w.check(start.Add(30 * time.Second))
+	writeFile(t, movie, "movie, longer")
+	w.check(start.Add(time.Minute))
+	if len(w.queue) != 0 {
+		t.Fatalf("expected changed file not to be queued")
+	}
+
+	// Partial download next to the file.
+	writeFile(t, movie+".aria2", "control file")
+	w.check(start.Add(2 * time.Minute))
+	if len(w.queue) != 0 {
+		t.Fatalf("expected file with a partial download not to be queued")
+	}
+	if err := os.Remove(movie + ".aria2"); err != nil {
+		t.Fatal(err)
+	}
+
+	w.check(start.Add(3 * time.Minute))
+	w.check(start.Add(4 * time.Minute))
+	if len(w.queue) != 1 || <-w.queue != movie {
+		t.Fatalf("expected %s to be queued", movie)
+	}
+
+	// Queue full: the file stays pending.
+	other := filepath.Join(dir, "other.mp4")
+	writeFile(t, other, "other")
+	w.queue <- "busy.mkv"
+	w.touch(other, start)
+	w.check(start.Add(time.Hour))
+	if w.pending[other] == nil {
+		t.Errorf("expected %s to stay pending with a full queue", other)
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	dir := t.TempDir()
+	processed := make(chan string, 10)
+	process := func(ctx context.Context, file string) { processed <- file }
+
+	w, err := newWatcher([]string{dir}, 100*time.Millisecond, 10, partialExtensions, process)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.run(ctx) }()
+
+	// Downloads are renamed to the final name when done.
+	writeFile(t, filepath.Join(dir, "movie.mkv.part"), "movie")
+	writeFile(t, filepath.Join(dir, "readme.txt"), "readme")
+	if err := os.Rename(filepath.Join(dir, "movie.mkv.part"), filepath.Join(dir, "movie.mkv")); err != nil {
+		t.Fatal(err)
+	}
+	// Directories moved into the watched directory are scanned.
+	tmp := t.TempDir()
+	writeFile(t, filepath.Join(tmp, "show", "show.mp4"), "show")
+	if err := os.Rename(filepath.Join(tmp, "show"), filepath.Join(dir, "show")); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]bool{
+		filepath.Join(dir, "movie.mkv"):        true,
+		filepath.Join(dir, "show", "show.mp4"): true,
+	}
+	for len(expected) > 0 {
+		select {
+		case file := <-processed:
+			if !expected[file] {
+				t.Fatalf("unexpected file processed: %s", file)
+			}
+			delete(expected, file)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %v", expected)
+		}
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	select {
+	case file := <-processed:
+		t.Errorf("unexpected file processed: %s", file)
+	default:
+	}
+}
+
+func TestWatcherOwnOutput(t *testing.T) {
+	dir := t.TempDir()
+	movie := filepath.Join(dir, "movie.mkv")
+	processed := make(chan string, 10)
+
+	// Rewrite the file in place, like fixing it does.
+	var w *watcher
+	process := func(ctx context.Context, file string) {
+		writeFile(t, file, "fixed movie")
+		w.wrote(file)
+		processed <- file
+	}
+	w, err := newWatcher([]string{dir}, 100*time.Millisecond, 10, partialExtensions, process)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- w.run(ctx) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}()
+
+	wait := func(timeout time.Duration) bool {
+		select {
+		case <-processed:
+			return true
+		case <-time.After(timeout):
+			return false
+		}
+	}
+
+	writeFile(t, movie, "movie")
+	if !wait(5 * time.Second) {
+		t.Fatalf("timeout waiting for %s", movie)
+	}
+	// The output is not processed again.
+	if wait(time.Second) {
+		t.Fatalf("expected %s not to be processed again", movie)
+	}
+	// Until something else changes it.
+	writeFile(t, movie, "new movie")
+	if !wait(5 * time.Second) {
+		t.Fatalf("timeout waiting for changed %s", movie)
+	}
+}
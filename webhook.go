@@ -0,0 +1,234 @@
+// Sonarr and Radarr webhooks.
+//
+// "videofix serve" accepts the webhooks sent by Sonarr and Radarr on import
+// (POST /webhook, "On Import" and "On Upgrade" in the Connect settings) and
+// queues a job for the imported file. Both send a "Download" event, with
+// isUpgrade set for upgrades. Paths are translated with --path-map
+// when Sonarr or Radarr run in a container with different mounts. When the
+// URL and API key of the application are given, videofix asks it to rescan
+// the series or movie after fixing the file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	appSonarr = "sonarr"
+	appRadarr = "radarr"
+	// rescanTimeout is the maximum time to wait for a rescan request.
+	rescanTimeout = 30 * time.Second
+)
+
+// pathMapping translates paths starting with From to start with To.
+type pathMapping struct {
+	From string
+	To   string
+}
+
+// pathMap is a flag.Value holding path mappings in the form from=to. The
+// flag can be repeated.
+type pathMap []pathMapping
+
+func (m *pathMap) String() string {
+	var ret []string
+	for _, pm := range *m {
+		ret = append(ret, pm.From+"="+pm.To)
+	}
+	return strings.Join(ret, ",")
+}
+
+func (m *pathMap) Set(value string) error {
+	from, to, ok := strings.Cut(value, "=")
+	if !ok || from == "" || to == "" {
+		return fmt.Errorf("invalid path mapping %q (use from=to)", value)
+	}
+	*m = append(*m, pathMapping{From: from, To: to})
+	return nil
+}
+
+// translate returns the path with the longest matching prefix replaced.
+// Prefixes only match whole path components.
+func (m pathMap) translate(p string) string {
+	best := -1
+	for i, pm := range m {
+		from := strings.TrimSuffix(pm.From, "/")
+		if p != from && !strings.HasPrefix(p, from+"/") {
+			continue
+		}
+		if best < 0 || len(from) > len(strings.TrimSuffix(m[best].From, "/")) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return p
+	}
+	rest := strings.TrimPrefix(p, strings.TrimSuffix(m[best].From, "/"))
+	return filepath.FromSlash(strings.TrimSuffix(m[best].To, "/") + rest)
+}
+
+// arrApp holds the URL and API key of a Sonarr or Radarr instance.
+type arrApp struct {
+	URL    string
+	APIKey string
+}
+
+// arrConfig holds the webhook settings.
+type arrConfig struct {
+	paths  pathMap
+	apps   map[string]arrApp // Keyed by appSonarr and appRadarr.
+	client *http.Client
+}
+
+// rescanRequest identifies the series or movie to rescan after a job.
+type rescanRequest struct {
+	App string `json:"app"`
+	ID  int    `json:"id"`
+}
+
+// arrFile holds the imported file in a webhook. Older versions only send
+// the path relative to the series or movie folder.
+type arrFile struct {
+	Path         string `json:"path"`
+	RelativePath string `json:"relativePath"`
+}
+
+// arrPayload holds the parts of a Sonarr or Radarr webhook used here.
+type arrPayload struct {
+	EventType string `json:"eventType"`
+	IsUpgrade bool   `json:"isUpgrade"`
+	Series    *struct {
+		ID   int    `json:"id"`
+		Path string `json:"path"`
+	} `json:"series"`
+	EpisodeFile *arrFile `json:"episodeFile"`
+	Movie       *struct {
+		ID         int    `json:"id"`
+		FolderPath string `json:"folderPath"`
+	} `json:"movie"`
+	MovieFile *arrFile `json:"movieFile"`
+}
+
+// imported returns the path of the imported file (as seen by Sonarr or
+// Radarr) and the series or movie to rescan.
+func (p arrPayload) imported() (string, rescanRequest, error) {
+	var file *arrFile
+	var dir string
+	var rescan rescanRequest
+	switch {
+	case p.Series != nil && p.EpisodeFile != nil:
+		file, dir, rescan = p.EpisodeFile, p.Series.Path, rescanRequest{App: appSonarr, ID: p.Series.ID}
+	case p.Movie != nil && p.MovieFile != nil:
+		file, dir, rescan = p.MovieFile, p.Movie.FolderPath, rescanRequest{App: appRadarr, ID: p.Movie.ID}
+	default:
+		return "", rescan, errors.New("no episode or movie file in webhook")
+	}
+	switch {
+	case file.Path != "":
+		return file.Path, rescan, nil
+	case dir != "" && file.RelativePath != "":
+		return path.Join(dir, file.RelativePath), rescan, nil
+	}
+	return "", rescan, errors.New("no file path in webhook")
+}
+
+// handleWebhook queues a job for the file imported by Sonarr or Radarr.
+// Other events are acknowledged and ignored.
+func (s *server) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	var payload arrPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid webhook: %w", err))
+		return
+	}
+	switch payload.EventType {
+	case "Download":
+	case "Test":
+		writeJSON(w, http.StatusOK, map[string]string{"message": "test received"})
+		return
+	default:
+		writeJSON(w, http.StatusOK, map[string]string{"message": fmt.Sprintf("ignoring %s event", payload.EventType)})
+		return
+	}
+
+	arrPath, rescan, err := payload.imported()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	file := s.arr.paths.translate(arrPath)
+	if _, err := os.Stat(file); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("file not found: %s (reported as %s, check --path-map)", file, arrPath))
+		return
+	}
+	j := job{Path: file}
+	if s.arr.apps[rescan.App].URL != "" {
+		j.Rescan = &rescan
+	}
+	j = s.add(j)
+	event := "import"
+	if payload.IsUpgrade {
+		event = "upgrade"
+	}
+	log.Printf("%s: Queued job %s from %s %s webhook.", file, j.ID, rescan.App, event)
+	w.Header().Set("Location", "/jobs/"+j.ID)
+	writeJSON(w, http.StatusCreated, j)
+}
+
+// rescan asks Sonarr or Radarr to rescan the series or movie of a job.
+func (s *server) rescan(ctx context.Context, j job) {
+	app := s.arr.apps[j.Rescan.App]
+	if app.URL == "" {
+		return
+	}
+	command := map[string]any{"name": "RescanSeries", "seriesId": j.Rescan.ID}
+	if j.Rescan.App == appRadarr {
+		command = map[string]any{"name": "RescanMovie", "movieId": j.Rescan.ID}
+	}
+	if err := app.command(ctx, s.arr.client, command); err != nil {
+		log.Printf("%s: WARNING: %s rescan failed: %v", j.Path, j.Rescan.App, err)
+		return
+	}
+	log.Printf("%s: Requested %s rescan.", j.Path, j.Rescan.App)
+}
+
+// command sends a command to the Sonarr or Radarr API.
+func (a arrApp) command(ctx context.Context, client *http.Client, command map[string]any) error {
+	ctx, cancel := context.WithTimeout(ctx, rescanTimeout)
+	defer cancel()
+	body, err := json.Marshal(command)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(a.URL, "/")+"/api/v3/command", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-Key", a.APIKey)
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/marcopaganini/videofix/fix"
+)
+
+func TestPathMap(t *testing.T) {
+	var m pathMap
+	for _, value := range []string{"/tv=/media/tv", "/tv/anime/=/media/anime", "/movies=/mnt/movies"} {
+		if err := m.Set(value); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := m.Set("/downloads"); err == nil {
+		t.Errorf("expected error for mapping without =")
+	}
+
+	testCases := []struct {
+		input    string
+		expected string
+	}{
+		{input: "/tv/Show/S01E01.mkv", expected: "/media/tv/Show/S01E01.mkv"},
+		{input: "/tv/anime/Show/S01E01.mkv", expected: "/media/anime/Show/S01E01.mkv"},
+		{input: "/movies", expected: "/mnt/movies"},
+		{input: "/tvshows/Show/S01E01.mkv", expected: "/tvshows/Show/S01E01.mkv"},
+		{input: "/other/movie.mkv", expected: "/other/movie.mkv"},
+	}
+	for _, tc := range testCases {
+		if result := m.translate(tc.input); result != filepath.FromSlash(tc.expected) {
+			t.Errorf("translate(%q): expected %q, got %q", tc.input, tc.expected, result)
+		}
+	}
+}
+
+// readFixture returns a webhook payload from testdata.
+func readFixture(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "webhooks", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestWebhook(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "tv", "The Show", "Season 01", "The Show - S01E01 - Pilot WEBDL-1080p.mkv"), "episode")
+	writeFile(t, filepath.Join(dir, "tv", "The Show", "Season 01", "The Show - S01E02 - Second WEBDL-1080p.mkv"), "episode")
+	writeFile(t, filepath.Join(dir, "movies", "The Movie (2020)", "The Movie (2020) Bluray-2160p.mkv"), "movie")
+
+	testCases := []struct {
+		fixture         string
+		pathMap         bool
+		expectedStatus  int
+		expectedPath    string
+		expectedRescan  rescanRequest
+		expectedUpgrade bool
+	}{
+		{
+			fixture:        "sonarr-download.json",
+			pathMap:        true,
+			expectedStatus: http.StatusCreated,
+			expectedPath:   "tv/The Show/Season 01/The Show - S01E01 - Pilot WEBDL-1080p.mkv",
+			expectedRescan: rescanRequest{App: appSonarr, ID: 42},
+		},
+		{
+			fixture:         "sonarr-relative-path.json",
+			pathMap:         true,
+			expectedStatus:  http.StatusCreated,
+			expectedPath:    "tv/The Show/Season 01/The Show - S01E02 - Second WEBDL-1080p.mkv",
+			expectedRescan:  rescanRequest{App: appSonarr, ID: 42},
+			expectedUpgrade: true,
+		},
+		{
+			fixture:         "radarr-upgrade.json",
+			pathMap:         true,
+			expectedStatus:  http.StatusCreated,
+			expectedPath:    "movies/The Movie (2020)/The Movie (2020) Bluray-2160p.mkv",
+			expectedRescan:  rescanRequest{App: appRadarr, ID: 7},
+			expectedUpgrade: true,
+		},
+		{fixture: "radarr-upgrade.json", expectedStatus: http.StatusBadRequest, expectedUpgrade: true},
+		{fixture: "sonarr-test.json", expectedStatus: http.StatusOK},
+		{fixture: "radarr-grab.json", expectedStatus: http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.fixture, func(t *testing.T) {
+			s, err := newServer("", nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			s.arr.apps = map[string]arrApp{appSonarr: {URL: "http://sonarr:8989"}, appRadarr: {URL: "http://radarr:7878"}}
+			if tc.pathMap {
+				s.arr.paths = pathMap{{From: "/tv", To: filepath.Join(dir, "tv")}, {From: "/movies", To: filepath.Join(dir, "movies")}}
+			}
+			ts := httptest.NewServer(s.handler())
+			defer ts.Close()
+
+			var payload arrPayload
+			if err := json.Unmarshal([]byte(readFixture(t, tc.fixture)), &payload); err != nil {
+				t.Fatal(err)
+			}
+			if payload.IsUpgrade != tc.expectedUpgrade {
+				t.Errorf("expected upgrade %v, got %v", tc.expectedUpgrade, payload.IsUpgrade)
+			}
+
+			status, data := request(t, ts, "POST", "/webhook", readFixture(t, tc.fixture))
+			if status != tc.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", tc.expectedStatus, status, data)
+			}
+			if tc.expectedStatus != http.StatusCreated {
+				if len(s.jobs) != 0 {
+					t.Errorf("expected no jobs, got %d", len(s.jobs))
+				}
+				return
+			}
+			var j job
+			if err := json.Unmarshal(data, &j); err != nil {
+				t.Fatal(err)
+			}
+			if expected := filepath.Join(dir, filepath.FromSlash(tc.expectedPath)); j.Path != expected {
+				t.Errorf("expected path %q, got %q", expected, j.Path)
+			}
+			if j.Rescan == nil || *j.Rescan != tc.expectedRescan {
+				t.Errorf("expected rescan %+v, got %+v", tc.expectedRescan, j.Rescan)
+			}
+		})
+	}
+}
+
+func TestWebhookRescan(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "movies", "The Movie (2020)", "The Movie (2020) Bluray-2160p.mkv"), "movie")
+
+	// Fake Radarr API.
+	commands := make(chan map[string]any, 1)
+	radarr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/command" || r.Header.Get("X-Api-Key") != "secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var command map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&command)
+		commands <- command
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer radarr.Close()
+
+	run := func(ctx context.Context, j job, fn fix.ProgressFunc) error { return nil }
+	s, err := newServer("", run)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s.arr = arrConfig{
+		paths:  pathMap{{From: "/movies", To: filepath.Join(dir, "movies")}},
+		apps:   map[string]arrApp{appRadarr: {URL: radarr.URL + "/", APIKey: "secret"}},
+		client: radarr.Client(),
+	}
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.work(ctx)
+
+	if status, data := request(t, ts, "POST", "/webhook", readFixture(t, "radarr-upgrade.json")); status != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", status, data)
+	}
+	command := <-commands
+	if command["name"] != "RescanMovie" || command["movieId"] != float64(7) {
+		t.Errorf("unexpected command: %v", command)
+	}
+	waitStatus(t, ts, "1", jobDone)
+}